@@ -1,3 +1,7 @@
 module github.com/bdwalton/synacor
 
 go 1.20
+
+require golang.org/x/term v0.13.0
+
+require golang.org/x/sys v0.13.0 // indirect
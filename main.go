@@ -1,32 +1,449 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"embed"
 	"encoding/binary"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bdwalton/synacor/synacor"
 )
 
-var binaryFile = flag.String("binary_file", "", "The binary program file.")
+//go:embed web
+var webFiles embed.FS
+
+var (
+	binaryFile = flag.String("binary_file", "", "The binary program file.")
+	endian     = flag.String("endian", "little", "Byte order of the binary program file: little or big.")
+	echoInput  = flag.Bool("echo_input", false, "Echo consumed input to the output. Useful when piping input.")
+	debug      = flag.Bool("debug", false, "Start the program under the debugger.")
+	dbgScript  = flag.String("debug_script", "", "Run the debugger commands in this file, then exit (or continue interactively with -debug).")
+	script     = flag.String("script", "", "Play this input script, with @wait, @snapshot and @set directives, before running as usual.")
+	entry      = flag.String("entry", "", "Start execution at this address instead of 0.")
+	runTo      = flag.String("runto", "", "Run until this address is reached, print the machine state and exit (or enter -debug).")
+	pauseHalt  = flag.Bool("pause_on_halt", false, "Enter the debugger when the program halts, to inspect its final state.")
+	tagStack   = flag.Bool("tag_stack", false, "Tag stack entries as data or return addresses.")
+	serve      = flag.String("serve", "", "Serve a web debugger for the program on this address, such as :8080.")
+	timeout    = flag.Duration("timeout", 0, "Stop the program if it runs longer than this; 0 means no limit.")
+	autoSnap   = flag.String("auto_snapshot", "", "Save a numbered snapshot into this directory at each input prompt.")
+	keepSnaps  = flag.Int("keep_snapshots", 0, "Keep only this many of the most recent auto-snapshots; 0 keeps all.")
+	newlines   = flag.String("newlines", "pass", "How to write line endings in program output: pass, crlf or strip_cr.")
+	noPrompt   = flag.Bool("no_prompt", false, "Never print the \"Input: \" prompt. By default it's shown only when stdin is a terminal.")
+	cmdFile    = flag.String("commands", "", "Feed the program each line of this file as input before reading stdin.")
+	logInput   = flag.Bool("log_input", false, "Log the address of each IN that waits for input.")
+	sanitize   = flag.String("sanitize_input", "raw", "Treatment of non-ASCII input: raw, strip, replace or reject.")
+	randInput  = flag.Bool("random_input", false, "Feed the program pseudo-random input instead of stdin.")
+	seed       = flag.Int64("seed", 0, "Seed for -random_input. Zero picks one from the clock.")
+	loopWindow = flag.Int("detect_loops", 0, "Halt if the machine repeats its state within this many steps. Zero disables.")
+	trace      = flag.String("trace", "", "Trace each instruction executed to stderr. Modes: plain or annotated.")
+	transcript = flag.String("transcript", "", "Write a numbered, timestamped copy of the program's output to this file.")
+	timings    = flag.Bool("timings", false, "Report time spent in each opcode when the program halts.")
+	report     = flag.Bool("report", false, "Report opcode counts and times, coverage and the hottest addresses when the program halts.")
+	hot        = flag.Int("hot", 0, "Report this many most executed addresses when the program halts.")
+	stateFile  = flag.String("state", "", "Load machine state from this file at startup, if it exists.")
+	saveState  = flag.Bool("save_state", false, "Save machine state to the -state file when the program halts.")
+	dumpRange  = flag.String("dumprange", "", "Write memory from start to end (exclusive), as start:end, to -dumpfile and exit.")
+	dumpFile   = flag.String("dumpfile", "dump.bin", "File written by -dumprange.")
+	selfTest   = flag.Bool("selftest", false, "Run the self-test quietly and print only its completion code.")
+	validate   = flag.Bool("validate", false, "Report words reachable both as opcodes and as operands, and exit.")
+	disasm     = flag.Bool("disassemble", false, "Write a disassembly of the program to stdout and exit.")
+	blocks     = flag.Bool("blocks", false, "Separate -disassemble output into basic blocks.")
+	asJSON     = flag.Bool("json", false, "Write -disassemble output as JSON.")
+	asmFile    = flag.String("assemble", "", "Assemble this source file to -assemble_out instead of running a binary.")
+	asmOut     = flag.String("assemble_out", "out.bin", "File written by -assemble.")
+	goldenDir  = flag.String("golden", "", "Check the golden programs in this directory instead of running a binary.")
+)
 
 func main() {
 	flag.Parse()
 
-	bin, err := os.ReadFile(*binaryFile)
+	if *asmFile != "" {
+		if err := assemble(*asmFile, *asmOut); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *goldenDir != "" {
+		failed, err := synacor.RunGolden(*goldenDir, os.Stdout)
+		if err != nil {
+			log.Fatalf("Couldn't run golden programs: %v", err)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var order binary.ByteOrder
+	switch *endian {
+	case "little":
+		order = binary.LittleEndian
+	case "big":
+		order = binary.BigEndian
+	default:
+		log.Fatalf("Unknown byte order %q; want little or big.", *endian)
+	}
+
+	f, err := os.Open(*binaryFile)
 	if err != nil {
 		log.Fatalf("Couldn't open %q: %v", *binaryFile, err)
 	}
+	defer f.Close()
+
+	m, err := synacor.NewMachineFromReader(f, order)
+	if err != nil {
+		log.Fatalf("Couldn't load %q: %v", *binaryFile, err)
+	}
+	if *debug || *dbgScript != "" || *pauseHalt {
+		// Keep the image as loaded for the debugger's drift command.
+		m.RetainOriginal()
+	}
+	m.SetEchoInput(*echoInput)
+	m.SetLogInput(*logInput)
+
+	switch *sanitize {
+	case "raw":
+		m.SetInputSanitize(synacor.INPUT_RAW)
+	case "strip":
+		m.SetInputSanitize(synacor.INPUT_STRIP)
+	case "replace":
+		m.SetInputSanitize(synacor.INPUT_REPLACE)
+	case "reject":
+		m.SetInputSanitize(synacor.INPUT_REJECT)
+	default:
+		log.Fatalf("Unknown input sanitizing mode %q.", *sanitize)
+	}
 
-	prog := make([]uint16, 0)
+	if *selfTest {
+		m.SetOutput(io.Discard)
+		code, err := m.RunSelfTest()
+		if err != nil {
+			log.Fatalf("Self-test code not found: %v", err)
+		}
+		fmt.Println(code)
+		return
+	}
+
+	if *validate {
+		overlaps := m.ValidateImage()
+		for _, o := range overlaps {
+			fmt.Printf("0x%04x is an opcode and an operand of the instruction at 0x%04x\n", o.Addr, o.Owner)
+		}
+		if len(overlaps) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *disasm {
+		w := bufio.NewWriter(os.Stdout)
+		disassemble := m.Disassemble
+		if *blocks {
+			disassemble = m.DisassembleBlocks
+		}
+		if *asJSON {
+			disassemble = func(w io.Writer, start, end uint16) error { return m.ProgramJSON(w) }
+		}
+		if err := disassemble(w, 0, uint16(m.ProgramLen())); err != nil {
+			log.Fatalf("Couldn't disassemble: %v", err)
+		}
+		w.Flush()
+		return
+	}
+
+	if *dumpRange != "" {
+		if err := dumpMemory(m, *dumpRange, *dumpFile); err != nil {
+			log.Fatalf("Couldn't dump memory: %v", err)
+		}
+		return
+	}
+
+	if *stateFile != "" {
+		if err := m.LoadState(*stateFile); err == nil {
+			log.Printf("Resuming from %q.", *stateFile)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			log.Fatal(err)
+		}
+	}
+	if *entry != "" {
+		addr, err := strconv.ParseUint(*entry, 0, 15)
+		if err != nil {
+			log.Fatalf("Invalid -entry address %q.", *entry)
+		}
+		m.SetPC(uint16(addr))
+	}
+	if *tagStack {
+		m.EnableStackTags()
+	}
+	m.DetectLoops(*loopWindow, nil)
+
+	if *transcript != "" {
+		tf, err := os.Create(*transcript)
+		if err != nil {
+			log.Fatalf("Couldn't create transcript: %v", err)
+		}
+		defer tf.Close()
+
+		t := synacor.NewTranscript(tf)
+		defer t.Flush()
+		m.SetTranscript(t)
+	}
+
+	switch *trace {
+	case "":
+	case "plain":
+		m.SetTracer(os.Stderr)
+	case "annotated":
+		m.SetTracer(os.Stderr)
+		m.SetTraceMode(synacor.TRACE_ANNOTATED)
+	default:
+		log.Fatalf("Unknown trace mode %q.", *trace)
+	}
+	if *timings {
+		m.EnableTimings()
+	}
+	if *hot > 0 {
+		m.EnableProfile()
+	}
+	if *report {
+		m.EnableTimings()
+		m.EnableProfile()
+	}
+
+	if err := m.SetAutoSnapshot(*autoSnap, *keepSnaps); err != nil {
+		log.Fatalf("Couldn't set up auto-snapshots: %v", err)
+	}
+
+	switch *newlines {
+	case "pass":
+	case "crlf":
+		m.SetNewlineMode(synacor.NEWLINE_CRLF)
+	case "strip_cr":
+		m.SetNewlineMode(synacor.NEWLINE_STRIP_CR)
+	default:
+		log.Fatalf("Unknown newline mode %q.", *newlines)
+	}
+
+	if *noPrompt {
+		m.SetPrompt(synacor.PROMPT_NEVER)
+	}
+
+	if *cmdFile != "" {
+		text, err := os.ReadFile(*cmdFile)
+		if err != nil {
+			log.Fatalf("Couldn't read commands: %v", err)
+		}
+		m.SetCommands(strings.Split(strings.TrimSuffix(string(text), "\n"), "\n"))
+	}
+
+	if *randInput {
+		if *seed == 0 {
+			*seed = time.Now().UnixNano()
+		}
+		log.Printf("Using random input with seed %d.", *seed)
+		m.SetInputSource(synacor.NewSeededInput(*seed))
+	}
+
+	if *pauseHalt {
+		m.OnHalt(func(state int, reason string) {
+			fmt.Fprintf(os.Stderr, "\nProgram stopped: %s\n", reason)
+		})
+	}
+
+	if *script != "" {
+		f, err := os.Open(*script)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = m.RunInputScript(f)
+		f.Close()
+
+		if err != nil {
+			log.Fatalf("Script %q failed: %v", *script, err)
+		}
+	}
+
+	if *runTo != "" {
+		addr, err := strconv.ParseUint(*runTo, 0, 15)
+		if err != nil {
+			log.Fatalf("Invalid -runto address %q.", *runTo)
+		}
+
+		m.SetOneShotBreakpoint(uint16(addr))
+		m.Run()
+		if !m.AtBreakpoint() {
+			log.Printf("Program stopped before reaching 0x%04x.", addr)
+		}
+		fmt.Println(m)
+
+		if !*debug {
+			return
+		}
+	}
+
+	if *serve != "" {
+		site, err := newSite(m)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Serving the debugger on %s.", *serve)
+		log.Fatal(http.ListenAndServe(*serve, site))
+	}
 
-	for i := 0; i < len(bin); i += 2 {
-		val := binary.LittleEndian.Uint16(bin[i : i+2])
-		prog = append(prog, val)
+	if *debug || *dbgScript != "" {
+		d := synacor.NewDebugger(m, os.Stdout)
+
+		if *dbgScript != "" {
+			f, err := os.Open(*dbgScript)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = d.RunScript(f)
+			f.Close()
+
+			if err != nil && err != synacor.ErrQuit {
+				log.Fatalf("Couldn't read debugger script: %v", err)
+			}
+			if err == synacor.ErrQuit || !*debug {
+				return
+			}
+		}
+
+		d.REPL(os.Stdin)
+		return
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if err := m.RunContext(ctx); errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Timed out after %v: %v.", *timeout, err)
+	}
+
+	// A timeout leaves the machine running, which isn't a halt.
+	if *pauseHalt && m.Halted() {
+		synacor.NewDebugger(m, os.Stdout).REPL(os.Stdin)
+	}
+
+	if *saveState && *stateFile != "" {
+		if err := m.SaveState(*stateFile); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Saved state to %q.", *stateFile)
+	}
+
+	if *timings {
+		reportTimings(m)
+	}
+
+	if *report {
+		m.Report(os.Stderr)
+	}
+
+	if *hot > 0 {
+		for _, h := range m.HotAddresses(*hot) {
+			fmt.Fprintf(os.Stderr, "%10d %s\n", h.Count, h.Inst)
+		}
+	}
+}
+
+// Assemble the source in src, writing the program to out.
+func assemble(src, out string) error {
+	text, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	prog, err := synacor.Assemble(string(text))
+	if err != nil {
+		return fmt.Errorf("%s: %v", src, err)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+
+	if err := synacor.NewMachine(prog).DumpRange(0, uint16(len(prog)), f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Write the memory range rng, given as start:end, to path.
+func dumpMemory(m *synacor.Machine, rng, path string) error {
+	from, to, ok := strings.Cut(rng, ":")
+	if !ok {
+		return fmt.Errorf("range %q isn't start:end", rng)
+	}
+
+	start, err := strconv.ParseUint(from, 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid start %q", from)
+	}
+
+	end, err := strconv.ParseUint(to, 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid end %q", to)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := m.DumpRange(uint16(start), uint16(end), f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func reportTimings(m *synacor.Machine) {
+	t := m.Timings()
+	ops := make([]string, 0, len(t))
+	for op := range t {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	for _, op := range ops {
+		fmt.Fprintf(os.Stderr, "%-5s %v\n", op, t[op])
+	}
+}
+
+// newSite returns the web debugger for m: the embedded page, with the
+// control API under /api/.
+func newSite(m *synacor.Machine) (http.Handler, error) {
+	page, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		return nil, err
 	}
 
-	m := synacor.NewMachine(prog)
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(page)))
+	mux.Handle("/api/", synacor.NewServer(m))
 
-	m.Run()
+	return mux, nil
 }
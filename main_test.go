@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bdwalton/synacor/synacor"
+)
+
+func TestSite(t *testing.T) {
+	site, err := newSite(synacor.NewMachine([]uint16{synacor.HALT}))
+	if err != nil {
+		t.Fatalf("newSite: %v", err)
+	}
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{"/", "<title>synacor</title>"},
+		{"/api/state", `"state":"RUNNING"`},
+	} {
+		resp, err := http.Get(srv.URL + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), tc.want) {
+			t.Errorf("GET %s: %s, want 200 with %q in:\n%s", tc.path, resp.Status, tc.want, body)
+		}
+	}
+}
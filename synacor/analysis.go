@@ -0,0 +1,270 @@
+package synacor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrNeedInput is returned by RunUntilOutput when the program wants a
+// line of input that hasn't already been supplied.
+var ErrNeedInput = errors.New("waiting for input")
+
+// selfTestCode matches the canonical binary's announcement of the code
+// earned by passing the self-test.
+var selfTestCode = regexp.MustCompile(`self-test completion code is:\s*([A-Za-z0-9]+)\s`)
+
+// SELF_TEST_MARKER is the text the canonical challenge binary prints
+// just before running its self-test.
+const SELF_TEST_MARKER = "self-test"
+
+// FindSelfTest makes a best-effort guess at where the challenge's
+// self-test begins. It scans for a straight-line run of OUT
+// instructions with literal operands whose text mentions
+// SELF_TEST_MARKER, and returns the address of the first instruction
+// after the run. This is tuned for the canonical binary and may find
+// nothing, or the wrong thing, in others.
+func (m *Machine) FindSelfTest() (uint16, bool) {
+	var text strings.Builder
+
+	for addr := 0; addr < len(m.memory); {
+		inst, ok := m.Decode(uint16(addr))
+		if !ok {
+			text.Reset()
+			addr++
+			continue
+		}
+
+		if inst.Op == OUT && isValue(inst.Args[0]) {
+			text.WriteRune(rune(inst.Args[0]))
+		} else {
+			if strings.Contains(text.String(), SELF_TEST_MARKER) {
+				return inst.Addr, true
+			}
+			text.Reset()
+		}
+
+		addr += int(inst.Len())
+	}
+
+	return 0, false
+}
+
+// A Drift is a memory word that differs from the retained original
+// program image.
+type Drift struct {
+	Addr uint16
+	Orig uint16
+	Now  uint16
+}
+
+// RetainOriginal keeps a copy of memory as it is now, normally right
+// after loading, for CodeDrift to compare against.
+func (m *Machine) RetainOriginal() {
+	m.original = append([]uint16{}, m.memory...)
+}
+
+// CodeDrift returns every address whose contents have changed since
+// RetainOriginal was called, in address order. It returns nil if no
+// original was retained.
+func (m *Machine) CodeDrift() []Drift {
+	if m.original == nil {
+		return nil
+	}
+
+	drift := make([]Drift, 0)
+	for addr, orig := range m.original {
+		if now := m.memory[addr]; now != orig {
+			drift = append(drift, Drift{Addr: uint16(addr), Orig: orig, Now: now})
+		}
+	}
+
+	return drift
+}
+
+// RunUntilOutput runs the machine until the output it prints from now
+// on matches re, returning the match and its submatches. Output is
+// checked at the end of each line. It stops early with an error if the
+// machine halts, reaches a breakpoint, or would have to wait for a
+// fresh line of input.
+func (m *Machine) RunUntilOutput(re *regexp.Regexp) ([]string, error) {
+	var buf bytes.Buffer
+
+	out := m.out
+	m.out = io.MultiWriter(out, &buf)
+	defer func() { m.out = out }()
+
+	checked := 0
+	for {
+		if m.needsInput() {
+			return nil, ErrNeedInput
+		}
+
+		if err := m.StepN(1); err != nil {
+			return nil, err
+		}
+
+		if buf.Len() > checked && bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			checked = buf.Len()
+			if match := re.FindStringSubmatch(buf.String()); match != nil {
+				return match, nil
+			}
+		}
+	}
+}
+
+// RunSelfTest runs the canonical binary's self-test and returns the
+// completion code it prints.
+func (m *Machine) RunSelfTest() (string, error) {
+	match, err := m.RunUntilOutput(selfTestCode)
+	if err != nil {
+		return "", err
+	}
+
+	return match[1], nil
+}
+
+// Return every instruction statically reachable from entry, keyed by
+// address. Control flow is followed through fall-through, both arms of
+// JT and JF, and the literal targets of JMP and CALL; targets held in
+// registers, and RET, can't be followed.
+func (m *Machine) reach(entry uint16) map[uint16]Instruction {
+	code := make(map[uint16]Instruction)
+	work := []uint16{entry}
+
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		if _, ok := code[addr]; ok {
+			continue
+		}
+
+		inst, ok := m.Decode(addr)
+		if !ok {
+			continue
+		}
+		code[addr] = inst
+
+		if target, ok := branchTarget(inst); ok {
+			work = append(work, target)
+		}
+
+		switch inst.Op {
+		case HALT, JMP, RET:
+		default:
+			if isValue(inst.Next()) {
+				work = append(work, inst.Next())
+			}
+		}
+	}
+
+	return code
+}
+
+// An Overlap is a word reachable both as an opcode and as an operand.
+type Overlap struct {
+	Addr  uint16 // Address reached as an opcode
+	Owner uint16 // Instruction that has Addr as an operand
+}
+
+// ValidateImage follows control flow statically from address 0 and
+// reports, in address order, every word that is reached as an opcode
+// but is also an operand of another reachable instruction. That points
+// to a decoding mistake, data mistaken for code, or a program that
+// deliberately overlaps its instructions.
+func (m *Machine) ValidateImage() []Overlap {
+	code := m.reach(0)
+
+	overlaps := make([]Overlap, 0)
+	for _, inst := range code {
+		for i := range inst.Args {
+			addr := inst.Addr + 1 + uint16(i)
+			if _, ok := code[addr]; ok {
+				overlaps = append(overlaps, Overlap{Addr: addr, Owner: inst.Addr})
+			}
+		}
+	}
+
+	sort.Slice(overlaps, func(i, j int) bool {
+		if overlaps[i].Addr != overlaps[j].Addr {
+			return overlaps[i].Addr < overlaps[j].Addr
+		}
+		return overlaps[i].Owner < overlaps[j].Owner
+	})
+
+	return overlaps
+}
+
+// XRefs returns, in address order, every instruction in the loaded
+// program that has target as a literal operand, such as a JMP, JT, JF
+// or CALL to it. The program is decoded in sequence as Disassemble
+// does, so operand words aren't mistaken for references, and code only
+// reached through a register jump is still searched.
+func (m *Machine) XRefs(target uint16) []uint16 {
+	refs := make([]uint16, 0)
+	for addr, inst := range m.decoded() {
+		for _, arg := range inst.Args {
+			if arg == target {
+				refs = append(refs, addr)
+				break
+			}
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i] < refs[j] })
+
+	return refs
+}
+
+// An entry in the listing written by ProgramJSON. For data, Op holds
+// the word itself.
+type programEntry struct {
+	Addr     uint16   `json:"addr"`
+	Op       uint16   `json:"op"`
+	Mnemonic string   `json:"mnemonic"`
+	Args     []uint16 `json:"args"`
+	IsData   bool     `json:"isData"`
+}
+
+// ProgramJSON writes the loaded program to w as a JSON array with an
+// object for each instruction, in address order:
+//
+//	{"addr": 0, "op": 19, "mnemonic": "OUT", "args": [97], "isData": false}
+//
+// Code and data are told apart as Disassemble does. Every word it
+// writes as data gets an object of its own with isData set, its value
+// in op and an empty mnemonic.
+func (m *Machine) ProgramJSON(w io.Writer) error {
+	entries := make([]programEntry, 0)
+	m.walk(0, uint16(m.progLen), func(addr uint16, inst Instruction, ok bool) error {
+		if ok && validOperands(inst) {
+			entries = append(entries, programEntry{
+				Addr:     inst.Addr,
+				Op:       inst.Op,
+				Mnemonic: inst.Mnemonic,
+				Args:     inst.Args,
+			})
+			return nil
+		}
+
+		next := addr + 1
+		if ok {
+			next = inst.Next()
+		}
+		for a := addr; a < next; a++ {
+			entries = append(entries, programEntry{
+				Addr:   a,
+				Op:     m.memory[a],
+				Args:   []uint16{},
+				IsData: true,
+			})
+		}
+		return nil
+	})
+
+	return json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,145 @@
+package synacor
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Return assembly source printing s with one OUT per character.
+func outString(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		fmt.Fprintf(&b, "\t\tOUT %d\n", c)
+	}
+	return b.String()
+}
+
+func TestFindSelfTest(t *testing.T) {
+	src := outString("hi") + "NOOP\n" + outString("self-test") + "ADD r0 r0 1\nHALT"
+	m, _, _ := testMachine(t, src, "")
+
+	addr, ok := m.FindSelfTest()
+	if !ok || addr != 23 {
+		t.Errorf("FindSelfTest() = 0x%04x, %v; want 0x0017, true", addr, ok)
+	}
+
+	m, _, _ = testMachine(t, outString("hello")+"HALT", "")
+	if addr, ok := m.FindSelfTest(); ok {
+		t.Errorf("FindSelfTest() without the marker = 0x%04x, want not found", addr)
+	}
+}
+
+func TestCodeDrift(t *testing.T) {
+	const src = `
+		WMEM 20 7
+		WMEM 1 8
+		WMEM 22 0
+		HALT`
+
+	m, _, _ := testMachine(t, src, "")
+	m.Run()
+	if d := m.CodeDrift(); d != nil {
+		t.Errorf("CodeDrift() without RetainOriginal = %v, want nil", d)
+	}
+
+	m, _, _ = testMachine(t, src, "")
+	m.RetainOriginal()
+	m.Run()
+
+	// Writing a word's current value isn't drift.
+	want := []Drift{{Addr: 1, Orig: 20, Now: 8}, {Addr: 20, Orig: 0, Now: 7}}
+	if got := m.CodeDrift(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CodeDrift() = %v, want %v", got, want)
+	}
+}
+
+func TestRunSelfTest(t *testing.T) {
+	src := outString("Testing...\nThe self-test completion code is: AbC123\n") +
+		"IN r0\n" + outString("done\n") + "HALT"
+	m, out, _ := testMachine(t, src, "")
+
+	code, err := m.RunSelfTest()
+	if err != nil || code != "AbC123" {
+		t.Fatalf("RunSelfTest = %q, %v; want \"AbC123\"", code, err)
+	}
+	if !strings.HasSuffix(out.String(), "AbC123\n") {
+		t.Errorf("output = %q, want it to end with the code", out.String())
+	}
+
+	// The program now wants input nobody has given it.
+	if _, err := m.RunUntilOutput(regexp.MustCompile("done")); err != ErrNeedInput {
+		t.Errorf("RunUntilOutput at IN = %v, want ErrNeedInput", err)
+	}
+
+	m.SetCommands([]string{""})
+	if match, err := m.RunUntilOutput(regexp.MustCompile("(do)ne")); err != nil || !reflect.DeepEqual(match, []string{"done", "do"}) {
+		t.Errorf("RunUntilOutput = %q, %v; want [\"done\" \"do\"]", match, err)
+	}
+	if _, err := m.RunUntilOutput(regexp.MustCompile("never")); err != ErrHalted {
+		t.Errorf("RunUntilOutput at HALT = %v, want ErrHalted", err)
+	}
+}
+
+func TestValidateImage(t *testing.T) {
+	m, _, _ := testMachine(t, countLoop, "")
+	if got := m.ValidateImage(); len(got) != 0 {
+		t.Errorf("ValidateImage of a clean program = %v, want none", got)
+	}
+
+	// JF jumps into its own last operand, which decodes as PUSH 0 and
+	// so swallows the HALT after it.
+	m, _, _ = testMachine(t, "JF 0 2\nHALT", "")
+	want := []Overlap{{Addr: 2, Owner: 0}, {Addr: 3, Owner: 2}}
+	if got := m.ValidateImage(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ValidateImage = %v, want %v", got, want)
+	}
+}
+
+func TestXRefs(t *testing.T) {
+	m, _, _ := testMachine(t, `
+		CALL f
+		SET r0 f
+		HALT
+		JMP f      ; not statically reachable
+f:		RET
+		.word f    ; data`, "")
+
+	if got, want := m.XRefs(8), []uint16{0, 2, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("XRefs(0x0008) = %v, want %v", got, want)
+	}
+	if got := m.XRefs(0x1234); len(got) != 0 {
+		t.Errorf("XRefs(0x1234) = %v, want none", got)
+	}
+}
+
+func TestProgramJSON(t *testing.T) {
+	// The code after the data is only reached through a register, and
+	// the data includes an ADD with an operand that's out of range.
+	m, _, _ := testMachine(t, `
+		SET r0 code
+		JMP r0
+		.word 30000 9 32768 40000 1
+code:	OUT 'a'
+		HALT`, "")
+
+	var b strings.Builder
+	if err := m.ProgramJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `[{"addr":0,"op":1,"mnemonic":"SET","args":[32768,10],"isData":false},` +
+		`{"addr":3,"op":6,"mnemonic":"JMP","args":[32768],"isData":false},` +
+		`{"addr":5,"op":30000,"mnemonic":"","args":[],"isData":true},` +
+		`{"addr":6,"op":9,"mnemonic":"","args":[],"isData":true},` +
+		`{"addr":7,"op":32768,"mnemonic":"","args":[],"isData":true},` +
+		`{"addr":8,"op":40000,"mnemonic":"","args":[],"isData":true},` +
+		`{"addr":9,"op":1,"mnemonic":"","args":[],"isData":true},` +
+		`{"addr":10,"op":19,"mnemonic":"OUT","args":[97],"isData":false},` +
+		`{"addr":12,"op":0,"mnemonic":"HALT","args":[],"isData":false}]` + "\n"
+	if b.String() != want {
+		t.Errorf("ProgramJSON =\n%s\nwant\n%s", b.String(), want)
+	}
+}
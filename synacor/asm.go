@@ -0,0 +1,280 @@
+package synacor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Assemble translates assembly source into a program. Each line holds
+// at most one statement, optionally preceded by labels:
+//
+//	loop: ADD r0 r0 1     ; mnemonics are case-insensitive
+//	      JF r1, loop     ; operands may be separated by commas
+//	msg:  .string "hi\n"  ; one word per character
+//	      .word 0x1234 msg
+//
+// Operands are registers (r0..r7), numbers (decimal or 0x hex),
+// character literals ('a') or labels. A leading address such as
+// "0x0012:", as the disassembler writes, is checked against the
+// address being assembled.
+func Assemble(src string) ([]uint16, error) {
+	a := &assembler{
+		labels: make(map[string]uint16),
+		prog:   make([]uint16, 0),
+	}
+
+	for i, line := range strings.Split(src, "\n") {
+		if err := a.line(line); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+	}
+
+	for _, f := range a.fixups {
+		addr, ok := a.labels[f.label]
+		if !ok {
+			return nil, fmt.Errorf("line %d: undefined label %q", f.line, f.label)
+		}
+		a.prog[f.addr] = addr
+	}
+
+	return a.prog, nil
+}
+
+var opsFromString map[string]uint16 = func() map[string]uint16 {
+	ops := make(map[string]uint16, len(opsToString))
+	for op, name := range opsToString {
+		ops[name] = uint16(op)
+	}
+	return ops
+}()
+
+type fixup struct {
+	addr  int    // Word to patch
+	label string // Label whose address goes there
+	line  int
+}
+
+type assembler struct {
+	labels map[string]uint16
+	fixups []fixup
+	prog   []uint16
+	lineNo int
+}
+
+func (a *assembler) line(line string) error {
+	a.lineNo++
+	line = strings.TrimSpace(stripComment(line))
+
+	// Peel off any labels and address annotations.
+	for {
+		i := strings.IndexByte(line, ':')
+		if i < 0 || strings.ContainsAny(line[:i], " \t\"'") {
+			break
+		}
+
+		if err := a.label(line[:i]); err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line[i+1:])
+	}
+
+	if line == "" {
+		return nil
+	}
+
+	word, rest := line, ""
+	if i := strings.IndexFunc(line, unicode.IsSpace); i >= 0 {
+		word, rest = line[:i], strings.TrimSpace(line[i:])
+	}
+
+	switch strings.ToLower(word) {
+	case ".word":
+		for _, tok := range operandFields(rest) {
+			if err := a.operand(tok, 0xffff); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ".string":
+		s, err := strconv.Unquote(rest)
+		if err != nil {
+			return fmt.Errorf("invalid string %s", rest)
+		}
+		for _, c := range s {
+			if c > MAX_15BIT {
+				return fmt.Errorf("character %q doesn't fit in 15 bits", c)
+			}
+			a.prog = append(a.prog, uint16(c))
+		}
+		return nil
+	}
+
+	op, ok := opsFromString[strings.ToUpper(word)]
+	if !ok {
+		return fmt.Errorf("unknown instruction %q", word)
+	}
+
+	args := operandFields(rest)
+	if want := int(argsForOp[int(op)]); len(args) != want {
+		return fmt.Errorf("%s takes %d operands, got %d", opsToString[int(op)], want, len(args))
+	}
+
+	a.prog = append(a.prog, op)
+	for _, arg := range args {
+		if err := a.operand(arg, MAX_15BIT); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Define a label at the current address, or check an address
+// annotation against it.
+func (a *assembler) label(name string) error {
+	here := uint16(len(a.prog))
+
+	if name != "" && unicode.IsDigit(rune(name[0])) {
+		addr, err := strconv.ParseUint(name, 0, 16)
+		if err != nil {
+			return fmt.Errorf("invalid address %q", name)
+		}
+		if uint16(addr) != here {
+			return fmt.Errorf("address 0x%04x doesn't match assembled address 0x%04x", addr, here)
+		}
+		return nil
+	}
+
+	if !isIdent(name) {
+		return fmt.Errorf("invalid label %q", name)
+	}
+
+	if _, ok := regName(name); ok {
+		return fmt.Errorf("label %q is a register name", name)
+	}
+
+	if _, ok := a.labels[name]; ok {
+		return fmt.Errorf("label %q redefined", name)
+	}
+
+	a.labels[name] = here
+	return nil
+}
+
+// Append the word for operand tok. Numbers may be at most max.
+func (a *assembler) operand(tok string, max uint64) error {
+	if r, ok := regName(tok); ok {
+		a.prog = append(a.prog, MAX_15BIT+1+r)
+		return nil
+	}
+
+	if len(tok) >= 3 && tok[0] == '\'' {
+		c, _, tail, err := strconv.UnquoteChar(tok[1:], '\'')
+		if err != nil || tail != "'" || c > MAX_15BIT {
+			return fmt.Errorf("invalid character %s", tok)
+		}
+		a.prog = append(a.prog, uint16(c))
+		return nil
+	}
+
+	if unicode.IsDigit(rune(tok[0])) {
+		v, err := strconv.ParseUint(tok, 0, 16)
+		if err != nil || v > max {
+			return fmt.Errorf("invalid number %q", tok)
+		}
+		a.prog = append(a.prog, uint16(v))
+		return nil
+	}
+
+	if !isIdent(tok) {
+		return fmt.Errorf("invalid operand %q", tok)
+	}
+
+	a.fixups = append(a.fixups, fixup{addr: len(a.prog), label: tok, line: a.lineNo})
+	a.prog = append(a.prog, 0)
+	return nil
+}
+
+// Return the register number named by s, if it names one.
+func regName(s string) (uint16, bool) {
+	s = strings.ToLower(s)
+	if len(s) == 2 && s[0] == 'r' && '0' <= s[1] && s[1] < '0'+NREGS {
+		return uint16(s[1] - '0'), true
+	}
+
+	return 0, false
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i, c := range s {
+		if !(c == '_' || unicode.IsLetter(c) || (i > 0 && unicode.IsDigit(c))) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Split operands on whitespace and commas, keeping character literals
+// such as ' ' and ',' whole.
+func operandFields(s string) []string {
+	fields := make([]string, 0)
+	var cur strings.Builder
+	inQuote, escaped := false, false
+
+	flush := func() {
+		if cur.Len() > 0 {
+			fields = append(fields, cur.String())
+			cur.Reset()
+		}
+	}
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case inQuote && c == '\\':
+			escaped = true
+		case c == '\'':
+			inQuote = !inQuote
+		case !inQuote && (c == ',' || unicode.IsSpace(c)):
+			flush()
+			continue
+		}
+		cur.WriteRune(c)
+	}
+	flush()
+
+	return fields
+}
+
+// Remove a ';' comment from line, ignoring any inside quotes.
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ';':
+			return line[:i]
+		}
+	}
+
+	return line
+}
@@ -0,0 +1,99 @@
+package synacor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	const src = `
+start:	SET r0, msg     ; point at the message
+loop:	rmem r1 r0
+		JF r1 done
+		OUT r1
+		ADD r0 r0 1
+		JMP loop
+done:	HALT
+msg:	.string "hi\n"
+		.word 0 0x7fff start`
+
+	prog, err := Assemble(src)
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+
+	want := []uint16{
+		SET, 32768, 18,
+		RMEM, 32769, 32768,
+		JF, 32769, 17,
+		OUT, 32769,
+		ADD, 32768, 32768, 1,
+		JMP, 3,
+		HALT,
+		'h', 'i', '\n',
+		0, 0x7fff, 0,
+	}
+	if !reflect.DeepEqual(prog, want) {
+		t.Errorf("Assemble =\n%v\nwant\n%v", prog, want)
+	}
+
+	roundTrip(t, prog)
+}
+
+// Check that disassembling prog and assembling the result gives prog
+// back exactly.
+func roundTrip(t *testing.T, prog []uint16) {
+	t.Helper()
+
+	var b strings.Builder
+	if err := NewMachine(prog).Disassemble(&b, 0, uint16(len(prog))); err != nil {
+		t.Fatalf("Disassemble: %v", err)
+	}
+
+	got, err := Assemble(b.String())
+	if err != nil {
+		t.Fatalf("Assemble(Disassemble(%v)): %v\n%s", prog, err, b.String())
+	}
+	if !reflect.DeepEqual(got, prog) {
+		t.Errorf("Assemble(Disassemble(%v)) = %v\n%s", prog, got, b.String())
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, prog := range [][]uint16{
+		{HALT},
+		{SET, 32768, 0x61, OUT, 32768, HALT},
+		{JMP, 4, 'h', 'i', CALL, 2, RET},
+		{NOOP, 0, 0x7fff, 0xffff, 32768, 32775},
+		{ADD, 32768, 32776, 1, HALT},       // Operand that's neither value nor register
+		{42, 9999, OUT, 'x', 21},           // Opcodes that don't exist
+		{HALT, ADD, 32768, 1},              // Truncated at the end
+		{WMEM, 32769, 32775, JT, 32768, 1}, // Registers everywhere
+	} {
+		roundTrip(t, prog)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		want string
+	}{
+		{"FOO r0", `line 1: unknown instruction "FOO"`},
+		{"HALT\nOUT", "line 2: OUT takes 1 operands, got 0"},
+		{"JMP nowhere", `line 1: undefined label "nowhere"`},
+		{"a: HALT\na: HALT", `line 2: label "a" redefined`},
+		{"r3: HALT", `line 1: label "r3" is a register name`},
+		{"OUT 32768", `line 1: invalid number "32768"`},
+		{".word 0x10000", `line 1: invalid number "0x10000"`},
+		{"OUT 'ab'", "line 1: invalid character 'ab'"},
+		{`.string "open`, `line 1: invalid string "open`},
+		{"HALT\n0x0000: HALT", "line 2: address 0x0000 doesn't match assembled address 0x0001"},
+	} {
+		_, err := Assemble(tc.src)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("Assemble(%q) = %v, want %q", tc.src, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package synacor
+
+type breakpoint struct {
+	ignore  int  // Number of hits to pass over before stopping
+	hits    int  // Number of times the address has been reached
+	oneShot bool // Remove once it stops execution
+}
+
+// SetBreakpoint stops Run each time the program counter reaches addr.
+func (m *Machine) SetBreakpoint(addr uint16) {
+	m.SetBreakpointN(addr, 0)
+}
+
+// SetBreakpointN stops Run when the program counter reaches addr, but
+// only after it has been passed over ignore times. Setting a breakpoint
+// resets its hit count.
+func (m *Machine) SetBreakpointN(addr uint16, ignore int) {
+	m.breakpoints[addr] = &breakpoint{ignore: ignore}
+}
+
+// SetOneShotBreakpoint stops Run the first time the program counter
+// reaches addr, then removes itself.
+func (m *Machine) SetOneShotBreakpoint(addr uint16) {
+	m.breakpoints[addr] = &breakpoint{oneShot: true}
+}
+
+// ClearBreakpoint removes any breakpoint at addr.
+func (m *Machine) ClearBreakpoint(addr uint16) {
+	delete(m.breakpoints, addr)
+}
+
+// Breakpoints returns the addresses with breakpoints set.
+func (m *Machine) Breakpoints() []uint16 {
+	addrs := make([]uint16, 0, len(m.breakpoints))
+	for addr := range m.breakpoints {
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
+
+// AtBreakpoint reports whether the machine is stopped at a breakpoint.
+func (m *Machine) AtBreakpoint() bool {
+	return m.atBreak
+}
+
+// Record a visit to the current program counter, reporting whether
+// execution should stop there. Resuming from a breakpoint doesn't count
+// as a second visit.
+func (m *Machine) checkBreakpoint() bool {
+	if m.atBreak {
+		m.atBreak = false
+		return false
+	}
+
+	bp, ok := m.breakpoints[m.pc]
+	if !ok {
+		return false
+	}
+
+	bp.hits++
+	if bp.hits <= bp.ignore {
+		return false
+	}
+
+	if bp.oneShot {
+		delete(m.breakpoints, m.pc)
+	}
+
+	m.atBreak = true
+	return true
+}
@@ -0,0 +1,57 @@
+package synacor
+
+import "testing"
+
+const countLoop = `
+		SET r0 0
+loop:	ADD r0 r0 1
+		EQ r1 r0 10
+		JF r1 loop
+		HALT`
+
+func TestBreakpointIgnore(t *testing.T) {
+	m, _, _ := testMachine(t, countLoop, "")
+	m.SetBreakpointN(3, 2)
+
+	// The loop body runs with r0 = 0, 1, then stops the third time.
+	for _, want := range []uint16{2, 3} {
+		m.Run()
+
+		if !m.AtBreakpoint() || m.PC() != 3 {
+			t.Fatalf("stopped at 0x%04x (at breakpoint %v), want breakpoint at 0x0003", m.PC(), m.AtBreakpoint())
+		}
+		if got := m.Register(0); got != want {
+			t.Errorf("r0 = %d at breakpoint, want %d", got, want)
+		}
+	}
+
+	m.ClearBreakpoint(3)
+	m.Run()
+	if !m.Halted() || m.Register(0) != 10 {
+		t.Errorf("after clearing, halted %v with r0 = %d, want halted with 10", m.Halted(), m.Register(0))
+	}
+}
+
+func TestOneShotBreakpoint(t *testing.T) {
+	m, _, _ := testMachine(t, countLoop, "")
+	m.SetOneShotBreakpoint(7)
+	m.Run()
+
+	if !m.AtBreakpoint() || m.PC() != 7 || m.Steps() != 2 {
+		t.Fatalf("stopped at 0x%04x after %d steps (at breakpoint %v), want breakpoint at 0x0007 after 2", m.PC(), m.Steps(), m.AtBreakpoint())
+	}
+
+	want := "pc: 0x0007 (RUNNING) after 2 steps\n" +
+		"regs: r0=0x0001 r1=0x0000 r2=0x0000 r3=0x0000 r4=0x0000 r5=0x0000 r6=0x0000 r7=0x0000\n" +
+		"stack (0):\n" +
+		"next: 0x0007: EQ r1 r0 0x000a"
+	if got := m.String(); got != want {
+		t.Errorf("String() =\n%s\nwant\n%s", got, want)
+	}
+
+	// The breakpoint is gone, so the loop runs to the end.
+	m.Run()
+	if !m.Halted() || m.Register(0) != 10 {
+		t.Errorf("halted %v with r0 = %d, want halted with 10", m.Halted(), m.Register(0))
+	}
+}
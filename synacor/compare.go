@@ -0,0 +1,88 @@
+package synacor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// COMPARE_STEP_LIMIT bounds CompareRuns for programs that never halt.
+const COMPARE_STEP_LIMIT = GOLDEN_STEP_LIMIT
+
+// A Divergence describes the first step at which two runs compared by
+// CompareRuns differ.
+type Divergence struct {
+	Step           int    // Steps executed by each machine, counting the one that diverged
+	PCA, PCB       uint16 // Address of the instruction each just executed
+	InstA, InstB   string // Disassembly of those instructions
+	StateA, StateB int
+	OutA, OutB     string // Everything each had printed
+}
+
+func (d *Divergence) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "runs diverge at step %d:\n", d.Step)
+	fmt.Fprintf(&b, "  A: %s, now %s, printed %q\n", d.InstA, statesToString[d.StateA], outputTail(d.OutA))
+	fmt.Fprintf(&b, "  B: %s, now %s, printed %q", d.InstB, statesToString[d.StateB], outputTail(d.OutB))
+
+	return b.String()
+}
+
+// The end of s, which is where two diverging outputs differ.
+func outputTail(s string) string {
+	const n = 40
+	if len(s) > n {
+		return "..." + s[len(s)-n:]
+	}
+	return s
+}
+
+// CompareRuns runs progA and progB in lock step, each fed input, and
+// returns the first step after which their output or state differ,
+// with a *Divergence describing it. If both halt identically it
+// returns -1 and nil. It gives up with an error after
+// COMPARE_STEP_LIMIT steps.
+func CompareRuns(progA, progB []uint16, input string) (int, error) {
+	var outA, outB bytes.Buffer
+	a := NewMachineWithOptions(progA, WithInput(strings.NewReader(input)), WithOutput(&outA), WithDiag(io.Discard))
+	b := NewMachineWithOptions(progB, WithInput(strings.NewReader(input)), WithOutput(&outB), WithDiag(io.Discard))
+
+	for step := 1; step <= COMPARE_STEP_LIMIT; step++ {
+		pcA, pcB := a.pc, b.pc
+		instA, instB := describeAt(a, pcA), describeAt(b, pcB)
+
+		a.Step()
+		b.Step()
+
+		if a.state != b.state || !bytes.Equal(outA.Bytes(), outB.Bytes()) {
+			return step, &Divergence{
+				Step:   step,
+				PCA:    pcA,
+				PCB:    pcB,
+				InstA:  instA,
+				InstB:  instB,
+				StateA: a.state,
+				StateB: b.state,
+				OutA:   outA.String(),
+				OutB:   outB.String(),
+			}
+		}
+
+		if a.Halted() {
+			return -1, nil
+		}
+	}
+
+	return -1, fmt.Errorf("neither run halted within %d steps", COMPARE_STEP_LIMIT)
+}
+
+// Disassemble the instruction at addr, before it executes.
+func describeAt(m *Machine, addr uint16) string {
+	if inst, ok := m.Decode(addr); ok {
+		return inst.String()
+	}
+
+	return fmt.Sprintf("0x%04x: <invalid>", addr)
+}
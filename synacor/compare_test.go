@@ -0,0 +1,44 @@
+package synacor
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompareRuns(t *testing.T) {
+	assemble := func(src string) []uint16 {
+		t.Helper()
+		prog, err := Assemble(src)
+		if err != nil {
+			t.Fatalf("Assemble: %v", err)
+		}
+		return prog
+	}
+
+	a := assemble("IN r0\nOUT r0\nIN r1\nOUT r1\nHALT")
+	b := assemble("IN r0\nOUT r0\nIN r1\nOUT 'X'\nHALT")
+
+	step, err := CompareRuns(a, b, "ab")
+	var d *Divergence
+	if step != 4 || !errors.As(err, &d) {
+		t.Fatalf("CompareRuns = %d, %v; want 4 and a *Divergence", step, err)
+	}
+	want := Divergence{
+		Step:   4,
+		PCA:    6,
+		PCB:    6,
+		InstA:  "0x0006: OUT r1",
+		InstB:  "0x0006: OUT 0x0058",
+		StateA: RUNNING,
+		StateB: RUNNING,
+		OutA:   "ab",
+		OutB:   "aX",
+	}
+	if *d != want {
+		t.Errorf("divergence = %+v, want %+v", *d, want)
+	}
+
+	if step, err := CompareRuns(a, a, "ab"); step != -1 || err != nil {
+		t.Errorf("CompareRuns of a program with itself = %d, %v; want -1, nil", step, err)
+	}
+}
@@ -0,0 +1,427 @@
+package synacor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrQuit is returned by Exec when the user asks to leave the debugger.
+var ErrQuit = errors.New("quit")
+
+// A Debugger drives a Machine interactively.
+type Debugger struct {
+	m        *Machine
+	out      io.Writer
+	watches  []*Expr
+	commands map[string]debugCommand
+}
+
+type debugCommand struct {
+	usage string
+	help  string
+	run   func(d *Debugger, args []string) error
+}
+
+func NewDebugger(m *Machine, out io.Writer) *Debugger {
+	d := &Debugger{m: m, out: out}
+	d.commands = map[string]debugCommand{
+		"step":     {"step [n]", "execute n instructions (default 1)", (*Debugger).cmdStep},
+		"next":     {"next", "step, running any CALL to completion", (*Debugger).cmdNext},
+		"continue": {"continue", "run until a breakpoint or halt", (*Debugger).cmdContinue},
+		"break":    {"break <addr> [ignore]", "stop at addr, optionally after ignore hits", (*Debugger).cmdBreak},
+		"clear":    {"clear <addr>", "remove the breakpoint at addr", (*Debugger).cmdClear},
+		"input":    {"input [clear]", "show, or discard, input not yet consumed", (*Debugger).cmdInput},
+		"poke":     {"poke <rN|mem[addr]> <val>", "change a register or memory word", (*Debugger).cmdPoke},
+		"regs":     {"regs", "show the registers", (*Debugger).cmdRegs},
+		"stack":    {"stack", "show the stack", (*Debugger).cmdStack},
+		"watch":    {"watch <expr>", "show expr each time execution stops", (*Debugger).cmdWatch},
+		"print":    {"print <expr>", "evaluate expr once", (*Debugger).cmdPrint},
+		"drift":    {"drift", "show memory changed since the program was loaded", (*Debugger).cmdDrift},
+		"xref":     {"xref <addr>", "list the instructions that refer to addr", (*Debugger).cmdXRef},
+		"selftest": {"selftest", "guess where the self-test begins", (*Debugger).cmdSelfTest},
+		"help":     {"help", "show this message", (*Debugger).cmdHelp},
+		"quit":     {"quit", "leave the debugger", (*Debugger).cmdQuit},
+	}
+
+	return d
+}
+
+// Short names for the most used commands.
+var debugAliases map[string]string = map[string]string{
+	"b": "break",
+	"c": "continue",
+	"s": "step",
+	"r": "regs",
+}
+
+// AddWatch registers an expression to be displayed each time execution
+// stops.
+func (d *Debugger) AddWatch(expr string) error {
+	e, err := ParseExpr(expr)
+	if err != nil {
+		return err
+	}
+
+	d.watches = append(d.watches, e)
+	return nil
+}
+
+// Exec runs a single debugger command line.
+func (d *Debugger) Exec(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	name := fields[0]
+	if full, ok := debugAliases[name]; ok {
+		name = full
+	}
+
+	cmd, ok := d.commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q; try \"help\"", fields[0])
+	}
+
+	return cmd.run(d, fields[1:])
+}
+
+// REPL reads and executes commands from in until EOF or quit.
+func (d *Debugger) REPL(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+
+	d.stopped()
+	for {
+		fmt.Fprintf(d.out, "(debug) ")
+		if !scanner.Scan() {
+			return
+		}
+
+		if err := d.Exec(scanner.Text()); err != nil {
+			if err == ErrQuit {
+				return
+			}
+			fmt.Fprintln(d.out, err)
+		}
+	}
+}
+
+// RunScript executes debugger commands from in, one per line, echoing
+// each to the output before running it. Blank lines and lines starting
+// with '#' are skipped. A failing command is reported and the script
+// carries on. It returns ErrQuit if the script quits.
+func (d *Debugger) RunScript(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fmt.Fprintf(d.out, "(debug) %s\n", line)
+		if err := d.Exec(line); err != nil {
+			if err == ErrQuit {
+				return err
+			}
+			fmt.Fprintln(d.out, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Report why execution stopped, where, and the value of each watch.
+func (d *Debugger) stopped() {
+	switch {
+	case d.m.Halted():
+		fmt.Fprintln(d.out, "Machine halted.")
+	case d.m.AtBreakpoint():
+		fmt.Fprintf(d.out, "Breakpoint at 0x%04x.\n", d.m.PC())
+	}
+
+	d.showInstruction()
+
+	for _, w := range d.watches {
+		if v, err := w.Eval(d.m); err != nil {
+			fmt.Fprintf(d.out, "  %s: %v\n", w, err)
+		} else {
+			fmt.Fprintf(d.out, "  %s = %d (0x%04x)\n", w, v, v)
+		}
+	}
+}
+
+func (d *Debugger) showInstruction() {
+	if inst, ok := d.m.Decode(d.m.PC()); ok {
+		fmt.Fprintln(d.out, inst)
+	} else {
+		fmt.Fprintf(d.out, "0x%04x: <invalid>\n", d.m.PC())
+	}
+}
+
+func parseAddr(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 0, 16)
+	if err != nil || v > MAX_15BIT {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+
+	return uint16(v), nil
+}
+
+func (d *Debugger) cmdStep(args []string) error {
+	if d.m.Halted() {
+		return errors.New("machine is halted")
+	}
+
+	n := 1
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+			return fmt.Errorf("invalid step count %q", args[0])
+		}
+	}
+
+	d.m.StepN(n)
+	d.stopped()
+	return nil
+}
+
+func (d *Debugger) cmdNext(args []string) error {
+	if d.m.Halted() {
+		return errors.New("machine is halted")
+	}
+
+	d.m.StepOver()
+	d.stopped()
+	return nil
+}
+
+func (d *Debugger) cmdContinue(args []string) error {
+	if d.m.Halted() {
+		return errors.New("machine is halted")
+	}
+
+	d.m.Run()
+	d.stopped()
+	return nil
+}
+
+func (d *Debugger) cmdBreak(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: break <addr> [ignore]")
+	}
+
+	addr, err := parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+
+	ignore := 0
+	if len(args) == 2 {
+		if ignore, err = strconv.Atoi(args[1]); err != nil || ignore < 0 {
+			return fmt.Errorf("invalid ignore count %q", args[1])
+		}
+	}
+
+	d.m.SetBreakpointN(addr, ignore)
+	return nil
+}
+
+func (d *Debugger) cmdClear(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: clear <addr>")
+	}
+
+	addr, err := parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+
+	d.m.ClearBreakpoint(addr)
+	return nil
+}
+
+func (d *Debugger) cmdRegs(args []string) error {
+	fmt.Fprintf(d.out, "pc=0x%04x", d.m.PC())
+	for i := 0; i < NREGS; i++ {
+		fmt.Fprintf(d.out, " r%d=0x%04x", i, d.m.Register(i))
+	}
+	fmt.Fprintln(d.out)
+
+	return nil
+}
+
+func (d *Debugger) cmdInput(args []string) error {
+	switch {
+	case len(args) == 1 && args[0] == "clear":
+		d.m.ClearInput()
+		return nil
+	case len(args) != 0:
+		return errors.New("usage: input [clear]")
+	}
+
+	var b strings.Builder
+	for _, c := range d.m.PendingInput() {
+		b.WriteRune(rune(c))
+	}
+	fmt.Fprintf(d.out, "%q\n", b.String())
+
+	return nil
+}
+
+func (d *Debugger) cmdPoke(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: poke <rN|mem[addr]> <val>")
+	}
+
+	v, err := strconv.ParseUint(args[1], 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid value %q", args[1])
+	}
+
+	var old uint16
+	if r, ok := regName(args[0]); ok {
+		old = d.m.Register(int(r))
+		err = d.m.SetRegister(int(r), uint16(v))
+	} else if strings.HasPrefix(args[0], "mem[") && strings.HasSuffix(args[0], "]") {
+		addr, perr := parseAddr(args[0][4 : len(args[0])-1])
+		if perr != nil {
+			return perr
+		}
+		old = d.m.ReadMemory(addr)
+		err = d.m.WriteMemory(addr, uint16(v))
+	} else {
+		return fmt.Errorf("can't poke %q; expected rN or mem[addr]", args[0])
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(d.out, "%s: 0x%04x -> 0x%04x\n", args[0], old, v)
+	return nil
+}
+
+func (d *Debugger) cmdStack(args []string) error {
+	entries := d.m.Stack().Slice()
+	if len(entries) == 0 {
+		fmt.Fprintln(d.out, "Stack is empty.")
+		return nil
+	}
+
+	// Top of stack first.
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		fmt.Fprintf(d.out, "  %3d: 0x%04x", i, e.Value)
+		if tag := stackTagsToString[e.Tag]; tag != "" {
+			fmt.Fprintf(d.out, " (%s)", tag)
+		}
+		fmt.Fprintln(d.out)
+	}
+
+	return nil
+}
+
+func (d *Debugger) cmdWatch(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: watch <expr>")
+	}
+
+	return d.AddWatch(strings.Join(args, " "))
+}
+
+func (d *Debugger) cmdPrint(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: print <expr>")
+	}
+
+	e, err := ParseExpr(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	v, err := e.Eval(d.m)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(d.out, "%d (0x%04x)\n", v, v)
+	return nil
+}
+
+func (d *Debugger) cmdDrift(args []string) error {
+	drift := d.m.CodeDrift()
+	if drift == nil {
+		return errors.New("the original program wasn't retained")
+	}
+
+	for _, dr := range drift {
+		fmt.Fprintf(d.out, "  0x%04x: 0x%04x -> 0x%04x\n", dr.Addr, dr.Orig, dr.Now)
+	}
+	fmt.Fprintf(d.out, "%d words changed.\n", len(drift))
+
+	return nil
+}
+
+func (d *Debugger) cmdSelfTest(args []string) error {
+	addr, ok := d.m.FindSelfTest()
+	if !ok {
+		return errors.New("couldn't find the self-test")
+	}
+
+	fmt.Fprintf(d.out, "Self-test appears to begin at 0x%04x.\n", addr)
+	return nil
+}
+
+func (d *Debugger) cmdXRef(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: xref <addr>")
+	}
+
+	addr, err := parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+
+	refs := d.m.XRefs(addr)
+	for _, ref := range refs {
+		inst, _ := d.m.Decode(ref)
+		fmt.Fprintf(d.out, "  %s\n", inst)
+	}
+	fmt.Fprintf(d.out, "%d references to 0x%04x.\n", len(refs), addr)
+
+	return nil
+}
+
+func (d *Debugger) cmdHelp(args []string) error {
+	names := make([]string, 0, len(d.commands))
+	for name := range d.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	aliases := make(map[string]string, len(debugAliases))
+	for alias, name := range debugAliases {
+		aliases[name] = alias
+	}
+
+	for _, name := range names {
+		cmd := d.commands[name]
+		help := cmd.help
+		if alias, ok := aliases[name]; ok {
+			help += fmt.Sprintf(" (alias %s)", alias)
+		}
+		fmt.Fprintf(d.out, "  %-26s %s\n", cmd.usage, help)
+	}
+
+	return nil
+}
+
+func (d *Debugger) cmdQuit(args []string) error {
+	return ErrQuit
+}
@@ -0,0 +1,79 @@
+package synacor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDebuggerPoke(t *testing.T) {
+	m, out, _ := testMachine(t, "OUT r0\nOUT 'x'\nHALT", "")
+	var dout strings.Builder
+	d := NewDebugger(m, &dout)
+
+	// Change the register the next instruction reads, then the operand
+	// of the one after.
+	for _, line := range []string{"poke r0 0x41", "step", "poke mem[3] 66", "step"} {
+		if err := d.Exec(line); err != nil {
+			t.Fatalf("Exec(%q): %v", line, err)
+		}
+	}
+
+	if out.String() != "AB" {
+		t.Errorf("output = %q, want \"AB\"", out.String())
+	}
+	if want := "r0: 0x0000 -> 0x0041\n"; !strings.HasPrefix(dout.String(), want) {
+		t.Errorf("debugger output = %q, want it to start %q", dout.String(), want)
+	}
+	if !strings.Contains(dout.String(), "mem[3]: 0x0078 -> 0x0042\n") {
+		t.Errorf("debugger output = %q, want the memory change reported", dout.String())
+	}
+
+	for _, line := range []string{"poke r0", "poke r9 1", "poke r0 32768", "poke pc 1", "poke r0 x"} {
+		if err := d.Exec(line); err == nil {
+			t.Errorf("Exec(%q) succeeded", line)
+		}
+	}
+	if m.Register(0) != 0x41 {
+		t.Errorf("r0 = 0x%04x after bad pokes, want 0x0041", m.Register(0))
+	}
+}
+
+func TestDebuggerScript(t *testing.T) {
+	m, _, _ := testMachine(t, countLoop, "")
+	var dout strings.Builder
+	d := NewDebugger(m, &dout)
+
+	script := `
+# Stop the second time round the loop.
+b 0x0007 1
+c
+r
+bogus
+clear 0x0007
+continue
+quit
+regs`
+	if err := d.RunScript(strings.NewReader(script)); err != ErrQuit {
+		t.Errorf("RunScript = %v, want ErrQuit", err)
+	}
+
+	// Comments are skipped, aliases expand, and a bad command doesn't
+	// stop the script. Nothing runs after quit.
+	want := `(debug) b 0x0007 1
+(debug) c
+Breakpoint at 0x0007.
+0x0007: EQ r1 r0 0x000a
+(debug) r
+pc=0x0007 r0=0x0002 r1=0x0000 r2=0x0000 r3=0x0000 r4=0x0000 r5=0x0000 r6=0x0000 r7=0x0000
+(debug) bogus
+unknown command "bogus"; try "help"
+(debug) clear 0x0007
+(debug) continue
+Machine halted.
+0x000e: HALT
+(debug) quit
+`
+	if dout.String() != want {
+		t.Errorf("script output =\n%s\nwant\n%s", dout.String(), want)
+	}
+}
@@ -0,0 +1,275 @@
+package synacor
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// An Instruction is a single decoded operation and its raw operands.
+type Instruction struct {
+	Addr     uint16   // Address of the opcode word
+	Op       uint16   // The opcode
+	Mnemonic string   // Name of the opcode
+	Args     []uint16 // Raw operand words, unresolved
+}
+
+// Len returns the number of memory words the instruction occupies.
+func (i Instruction) Len() uint16 {
+	return 1 + uint16(len(i.Args))
+}
+
+// Next returns the address immediately after the instruction.
+func (i Instruction) Next() uint16 {
+	return i.Addr + i.Len()
+}
+
+// String renders the instruction as a line of disassembly. Register
+// operands appear as rN and everything else as hex.
+func (i Instruction) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "0x%04x: %s", i.Addr, i.Mnemonic)
+	for _, arg := range i.Args {
+		b.WriteByte(' ')
+		b.WriteString(operandString(arg))
+	}
+
+	return b.String()
+}
+
+func operandString(arg uint16) string {
+	if isReg(arg) {
+		return fmt.Sprintf("r%d", decipherReg(arg))
+	}
+
+	return fmt.Sprintf("0x%04x", arg)
+}
+
+// Describe a destination operand: a register, or a memory address.
+func destString(arg uint16) string {
+	if isReg(arg) {
+		return operandString(arg)
+	}
+
+	return fmt.Sprintf("mem[0x%04x]", arg)
+}
+
+// Decode reads the instruction at addr. It returns false if the word
+// at addr isn't a known opcode or its operands would run past the end
+// of memory.
+func (m *Machine) Decode(addr uint16) (Instruction, bool) {
+	if int(addr) >= len(m.memory) {
+		return Instruction{}, false
+	}
+
+	op := m.memory[addr]
+	n, ok := argsForOp[int(op)]
+	if !ok {
+		return Instruction{}, false
+	}
+
+	end := int(addr) + 1 + int(n)
+	if end > len(m.memory) {
+		return Instruction{}, false
+	}
+
+	args := make([]uint16, n)
+	copy(args, m.memory[int(addr)+1:end])
+
+	return Instruction{
+		Addr:     addr,
+		Op:       op,
+		Mnemonic: opsToString[int(op)],
+		Args:     args,
+	}, true
+}
+
+// Instructions returns an iterator that yields instructions
+// sequentially from start, skipping over operand words. It stops at
+// the end of memory or the first word that doesn't decode.
+func (m *Machine) Instructions(start uint16) func() (Instruction, bool) {
+	addr := int(start)
+	done := false
+
+	return func() (Instruction, bool) {
+		if done || addr >= len(m.memory) {
+			return Instruction{}, false
+		}
+
+		inst, ok := m.Decode(uint16(addr))
+		if !ok {
+			done = true
+			return Instruction{}, false
+		}
+		addr += int(inst.Len())
+
+		return inst, true
+	}
+}
+
+// Call fn for each instruction from start up to end, in address
+// order. Words that don't decode, or that begin an instruction running
+// past end, are passed one at a time with ok false. Stops at the first
+// error from fn.
+func (m *Machine) walk(start, end uint16, fn func(addr uint16, inst Instruction, ok bool) error) error {
+	if int(end) > len(m.memory) {
+		end = uint16(len(m.memory))
+	}
+
+	for addr := start; addr < end; {
+		inst, ok := m.Decode(addr)
+		if ok && int(inst.Next()) > int(end) {
+			ok = false
+		}
+		if err := fn(addr, inst, ok); err != nil {
+			return err
+		}
+
+		if ok {
+			addr = inst.Next()
+		} else {
+			addr++
+		}
+	}
+
+	return nil
+}
+
+// Report whether each of inst's operands is a value or a register.
+func validOperands(inst Instruction) bool {
+	for _, arg := range inst.Args {
+		if arg > MAX_REG {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the instructions in the loaded program, keyed by address,
+// found by decoding it in sequence from address 0 as Disassemble does.
+// Unlike static reachability this isn't stopped by jumps through
+// registers, though data can happen to decode as code. Anything
+// Disassemble writes as data is left out.
+func (m *Machine) decoded() map[uint16]Instruction {
+	code := make(map[uint16]Instruction)
+	m.walk(0, uint16(m.progLen), func(addr uint16, inst Instruction, ok bool) error {
+		if ok && validOperands(inst) {
+			code[addr] = inst
+		}
+		return nil
+	})
+
+	return code
+}
+
+// Write one line of disassembly for the word or instruction at addr.
+// Words that don't decode, and instructions with an operand that's
+// neither a value nor a register, are written as .word directives so
+// the output assembles back to the same image.
+func (m *Machine) disassembleLine(w io.Writer, addr uint16, inst Instruction, ok bool) error {
+	words := []uint16{m.memory[addr]}
+	if ok {
+		words = m.memory[addr:inst.Next()]
+		ok = validOperands(inst)
+	}
+
+	if ok {
+		_, err := fmt.Fprintln(w, inst)
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "0x%04x: .word", addr)
+	for _, v := range words {
+		fmt.Fprintf(&b, " 0x%04x", v)
+	}
+
+	_, err := fmt.Fprintln(w, b.String())
+	return err
+}
+
+// Disassemble writes a line of disassembly for each instruction from
+// start up to end. Words that don't decode are written as data. The
+// disassembly of a whole image from address 0 is valid input to
+// Assemble, which reproduces the image exactly.
+func (m *Machine) Disassemble(w io.Writer, start, end uint16) error {
+	return m.walk(start, end, func(addr uint16, inst Instruction, ok bool) error {
+		return m.disassembleLine(w, addr, inst, ok)
+	})
+}
+
+// Opcodes after which execution doesn't simply fall through.
+var controlOps map[uint16]bool = map[uint16]bool{
+	HALT: true,
+	JMP:  true,
+	JT:   true,
+	JF:   true,
+	CALL: true,
+	RET:  true,
+}
+
+// Return the literal destination of a branch instruction, if it has one.
+func branchTarget(inst Instruction) (uint16, bool) {
+	var target uint16
+	switch inst.Op {
+	case JMP, CALL:
+		target = inst.Args[0]
+	case JT, JF:
+		target = inst.Args[1]
+	default:
+		return 0, false
+	}
+
+	return target, isValue(target)
+}
+
+// BasicBlocks returns, in order, the addresses between start and end
+// at which a basic block begins: start itself, the literal targets of
+// JMP, JT, JF and CALL, and the instruction after any control flow.
+// Targets held in registers can't be found statically.
+func (m *Machine) BasicBlocks(start, end uint16) []uint16 {
+	starts := map[uint16]bool{start: true}
+	prevControl := false
+
+	m.walk(start, end, func(addr uint16, inst Instruction, ok bool) error {
+		if prevControl {
+			starts[addr] = true
+		}
+
+		prevControl = ok && controlOps[inst.Op]
+		if target, ok := branchTarget(inst); ok && start <= target && target < end {
+			starts[target] = true
+		}
+
+		return nil
+	})
+
+	blocks := make([]uint16, 0, len(starts))
+	for addr := range starts {
+		blocks = append(blocks, addr)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+
+	return blocks
+}
+
+// DisassembleBlocks is Disassemble, with a blank line and a header
+// comment before each basic block found by BasicBlocks.
+func (m *Machine) DisassembleBlocks(w io.Writer, start, end uint16) error {
+	starts := make(map[uint16]bool)
+	for _, addr := range m.BasicBlocks(start, end) {
+		starts[addr] = true
+	}
+
+	return m.walk(start, end, func(addr uint16, inst Instruction, ok bool) error {
+		if starts[addr] {
+			if _, err := fmt.Fprintf(w, "\n; block 0x%04x\n", addr); err != nil {
+				return err
+			}
+		}
+
+		return m.disassembleLine(w, addr, inst, ok)
+	})
+}
@@ -0,0 +1,102 @@
+package synacor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInstructions(t *testing.T) {
+	// Operands 19 and 21 are also the opcodes for OUT and NOOP, and
+	// must be skipped rather than decoded.
+	m, _, _ := testMachine(t, `
+		SET r0 19
+		ADD r1 r0 21
+		OUT r1
+		NOOP
+		.word 30000
+		HALT`, "")
+
+	var ops []uint16
+	var addrs []uint16
+	next := m.Instructions(0)
+	for {
+		inst, ok := next()
+		if !ok {
+			break
+		}
+		ops = append(ops, inst.Op)
+		addrs = append(addrs, inst.Addr)
+	}
+
+	if want := []uint16{SET, ADD, OUT, NOOP}; !reflect.DeepEqual(ops, want) {
+		t.Errorf("opcodes = %v, want %v", ops, want)
+	}
+	if want := []uint16{0, 3, 7, 9}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("addresses = %v, want %v", addrs, want)
+	}
+
+	if _, ok := next(); ok {
+		t.Errorf("iterator yielded more after stopping")
+	}
+}
+
+func TestInstructionString(t *testing.T) {
+	for _, tc := range []struct {
+		inst Instruction
+		want string
+	}{
+		{Instruction{Addr: 0x10, Op: HALT, Mnemonic: "HALT"}, "0x0010: HALT"},
+		{Instruction{Addr: 0x11, Op: OUT, Mnemonic: "OUT", Args: []uint16{'a'}}, "0x0011: OUT 0x0061"},
+		{Instruction{Addr: 0x13, Op: SET, Mnemonic: "SET", Args: []uint16{0x8007, 0x7fff}}, "0x0013: SET r7 0x7fff"},
+		{Instruction{Addr: 0x16, Op: ADD, Mnemonic: "ADD", Args: []uint16{0x8000, 0x8001, 4}}, "0x0016: ADD r0 r1 0x0004"},
+	} {
+		if got := tc.inst.String(); got != tc.want {
+			t.Errorf("String() = %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	m, _, _ := testMachine(t, "SET r0 'a'\nOUT r0\nHALT\n.word 30000", "")
+
+	var b strings.Builder
+	if err := m.Disassemble(&b, 0, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "0x0000: SET r0 0x0061\n0x0003: OUT r0\n0x0005: HALT\n0x0006: .word 0x7530\n"
+	if b.String() != want {
+		t.Errorf("Disassemble =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestBasicBlocks(t *testing.T) {
+	m, _, _ := testMachine(t, countLoop, "")
+
+	// The loop head is a target and HALT follows the loop's branch.
+	if got, want := m.BasicBlocks(0, 15), []uint16{0, 3, 14}; !reflect.DeepEqual(got, want) {
+		t.Errorf("BasicBlocks = %v, want %v", got, want)
+	}
+
+	var b strings.Builder
+	if err := m.DisassembleBlocks(&b, 0, 15); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `
+; block 0x0000
+0x0000: SET r0 0x0000
+
+; block 0x0003
+0x0003: ADD r0 r0 0x0001
+0x0007: EQ r1 r0 0x000a
+0x000b: JF r1 0x0003
+
+; block 0x000e
+0x000e: HALT
+`
+	if b.String() != want {
+		t.Errorf("DisassembleBlocks =\n%s\nwant\n%s", b.String(), want)
+	}
+}
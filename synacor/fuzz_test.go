@@ -0,0 +1,80 @@
+package synacor
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+// FuzzStep runs arbitrary images, starting from arbitrary registers and
+// stack, for a bounded number of steps. However malformed the program,
+// the machine must fault cleanly rather than panic.
+func FuzzStep(f *testing.F) {
+	for _, src := range []string{
+		countLoop,
+		echoLine,
+		"MOD r0 1 0\nHALT",
+		"RMEM r0 bad\nJMP r0\nbad: .word 32770",
+		"CALL f\nHALT\nf: PUSH 7\nRET",
+		"WMEM 0 21\nJMP 0",
+		".word 22 32768 32776",
+	} {
+		prog, err := Assemble(src)
+		if err != nil {
+			f.Fatalf("Assemble(%q): %v", src, err)
+		}
+
+		f.Add(wordBytes(prog), wordBytes([]uint16{0, 1, 7, 32767}), wordBytes([]uint16{3, 40000}))
+	}
+
+	f.Fuzz(func(t *testing.T, b, regs, stack []byte) {
+		m := NewMachineWithOptions(bytesWords(b),
+			WithInput(strings.NewReader("north\n")),
+			WithOutput(io.Discard),
+			WithDiag(io.Discard),
+			WithStepLimit(1000))
+
+		// Registers only ever hold 15-bit values, but anything at all
+		// can be pushed, as by LoadStack.
+		for i, v := range bytesWords(regs) {
+			if i < NREGS {
+				m.SetRegister(i, v&MAX_15BIT)
+			}
+		}
+		for _, v := range bytesWords(stack) {
+			m.stack.Push(v)
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("panic running % x with registers % x and stack % x: %v", b, regs, stack, r)
+			}
+		}()
+		m.Run()
+
+		switch m.State() {
+		case RUNNING, HALTED, ERROR:
+		default:
+			t.Errorf("state = %d, want RUNNING, HALTED or ERROR", m.State())
+		}
+	})
+}
+
+// Encode words as little-endian bytes.
+func wordBytes(words []uint16) []byte {
+	b := make([]byte, 2*len(words))
+	for i, v := range words {
+		binary.LittleEndian.PutUint16(b[2*i:], v)
+	}
+	return b
+}
+
+// Decode little-endian bytes as words, ignoring any odd byte.
+func bytesWords(b []byte) []uint16 {
+	words := make([]uint16, len(b)/2)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return words
+}
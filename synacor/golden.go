@@ -0,0 +1,157 @@
+package synacor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// GOLDEN_STEP_LIMIT bounds each golden program so a regression that
+// loops forever fails instead of hanging.
+const GOLDEN_STEP_LIMIT = 1000000
+
+// A GoldenCase is a program with a known transcript. For a program
+// NAME.bin, the input it's fed is read from NAME.in (optional) and the
+// output it must produce is read from NAME.expected.
+type GoldenCase struct {
+	Name     string
+	Binary   string
+	Input    string
+	Expected string
+}
+
+// FindGoldenCases returns the golden cases in dir, sorted by name. A
+// binary without an expected transcript is an error.
+func FindGoldenCases(dir string) ([]GoldenCase, error) {
+	bins, err := filepath.Glob(filepath.Join(dir, "*.bin"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(bins)
+
+	cases := make([]GoldenCase, 0, len(bins))
+	for _, bin := range bins {
+		base := strings.TrimSuffix(bin, ".bin")
+		c := GoldenCase{
+			Name:     filepath.Base(base),
+			Binary:   bin,
+			Expected: base + ".expected",
+		}
+
+		if _, err := os.Stat(c.Expected); err != nil {
+			return nil, fmt.Errorf("%s has no expected output: %v", bin, err)
+		}
+
+		if _, err := os.Stat(base + ".in"); err == nil {
+			c.Input = base + ".in"
+		}
+
+		cases = append(cases, c)
+	}
+
+	return cases, nil
+}
+
+// Run executes the case's program with its input until it halts or
+// executes stepLimit instructions, returning everything it printed.
+func (c GoldenCase) Run(stepLimit int) (string, error) {
+	f, err := os.Open(c.Binary)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	input := []byte{}
+	if c.Input != "" {
+		if input, err = os.ReadFile(c.Input); err != nil {
+			return "", err
+		}
+	}
+
+	var out bytes.Buffer
+	m, err := NewMachineFromReader(f, binary.LittleEndian,
+		WithInput(bytes.NewReader(input)),
+		WithOutput(&out),
+		WithStepLimit(stepLimit))
+	if err != nil {
+		return "", err
+	}
+
+	m.Run()
+
+	if m.state != HALTED {
+		return out.String(), fmt.Errorf("machine stopped at 0x%04x after %d steps without halting", m.pc, m.steps)
+	}
+
+	return out.String(), nil
+}
+
+// Check runs the case and describes the first difference between its
+// output and the expected transcript.
+func (c GoldenCase) Check(stepLimit int) error {
+	want, err := os.ReadFile(c.Expected)
+	if err != nil {
+		return err
+	}
+
+	got, err := c.Run(stepLimit)
+	if err != nil {
+		return err
+	}
+
+	return diffTranscripts(string(want), got)
+}
+
+// Report the first line where got differs from want.
+func diffTranscripts(want, got string) error {
+	if want == got {
+		return nil
+	}
+
+	wl, gl := strings.SplitAfter(want, "\n"), strings.SplitAfter(got, "\n")
+	for i := 0; i < len(wl) || i < len(gl); i++ {
+		var w, g string
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if i < len(gl) {
+			g = gl[i]
+		}
+
+		if w != g {
+			return fmt.Errorf("output differs at line %d:\n  want: %q\n  got:  %q", i+1, w, g)
+		}
+	}
+
+	return nil
+}
+
+// RunGolden checks every golden case in dir, writing a PASS or FAIL
+// line for each to w. It returns the number of failed cases.
+func RunGolden(dir string, w io.Writer) (int, error) {
+	cases, err := FindGoldenCases(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(cases) == 0 {
+		return 0, fmt.Errorf("no golden programs in %q", dir)
+	}
+
+	failed := 0
+	for _, c := range cases {
+		if err := c.Check(GOLDEN_STEP_LIMIT); err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL %s: %v\n", c.Name, err)
+		} else {
+			fmt.Fprintf(w, "PASS %s\n", c.Name)
+		}
+	}
+
+	return failed, nil
+}
@@ -0,0 +1,33 @@
+package synacor
+
+import "testing"
+
+func TestGolden(t *testing.T) {
+	cases, err := FindGoldenCases("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatal("no golden cases in testdata")
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			if err := c.Check(GOLDEN_STEP_LIMIT); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDiffTranscripts(t *testing.T) {
+	if err := diffTranscripts("a\nb\n", "a\nb\n"); err != nil {
+		t.Errorf("identical transcripts differ: %v", err)
+	}
+
+	err := diffTranscripts("a\nb\nc\n", "a\nx\nc\n")
+	if err == nil || err.Error() != "output differs at line 2:\n  want: \"b\\n\"\n  got:  \"x\\n\"" {
+		t.Errorf("diffTranscripts error = %v", err)
+	}
+}
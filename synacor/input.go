@@ -0,0 +1,190 @@
+package synacor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Treatment of non-ASCII characters in input.
+const (
+	INPUT_RAW     = iota // Default. Pass each character through.
+	INPUT_STRIP          // Drop non-ASCII characters.
+	INPUT_REPLACE        // Replace non-ASCII characters with '?'.
+	INPUT_REJECT         // Discard the line and ask again.
+)
+
+// SetInputSanitize chooses how characters outside ASCII are handled
+// when read. Pasted text often contains smart quotes and the like,
+// which the program can't make sense of.
+func (m *Machine) SetInputSanitize(mode int) {
+	m.sanitize = mode
+}
+
+// What happens once the commands given to SetCommands run out.
+const (
+	COMMANDS_THEN_INPUT = iota // Default. Read from the input source.
+	COMMANDS_THEN_HALT         // Halt the machine.
+)
+
+// SetCommands queues lines of input, each implicitly ending in a
+// newline, to be consumed by IN in order before anything is read from
+// the input source.
+func (m *Machine) SetCommands(cmds []string) {
+	m.commands = append([]string{}, cmds...)
+}
+
+// SetCommandsFallback chooses what happens once the queued commands are
+// used up: COMMANDS_THEN_INPUT or COMMANDS_THEN_HALT.
+func (m *Machine) SetCommandsFallback(mode int) {
+	m.commandsThen = mode
+}
+
+// Return the next queued command, or else a line from the input source.
+func (m *Machine) nextLine() (string, error) {
+	if len(m.commands) > 0 {
+		line := m.commands[0] + "\n"
+		m.commands = m.commands[1:]
+		return line, nil
+	}
+
+	return m.input.ReadString('\n')
+}
+
+// PendingInput returns a copy of the input that has been read but not
+// yet consumed by IN.
+func (m *Machine) PendingInput() []uint16 {
+	return append([]uint16{}, m.unused_input...)
+}
+
+// ClearInput discards any input read but not yet consumed, so the next
+// IN reads a fresh line.
+func (m *Machine) ClearInput() {
+	m.unused_input = m.unused_input[:0]
+}
+
+// Report whether the next instruction is an IN that would have to read
+// a fresh line of input.
+func (m *Machine) needsInput() bool {
+	return !m.Halted() && int(m.pc) < len(m.memory) && m.memory[m.pc] == IN && len(m.unused_input) == 0 && len(m.commands) == 0
+}
+
+// SetLogInput reports to the diagnostic writer each time IN has to
+// wait for a line of input, with the address of the IN and where the
+// character will be stored.
+func (m *Machine) SetLogInput(log bool) {
+	m.logInput = log
+}
+
+// Read a line of input into unused_input, reporting whether any was
+// available. The machine is put in an error state if not.
+func (m *Machine) readInput() bool {
+	for {
+		if m.commands != nil && len(m.commands) == 0 && m.commandsThen == COMMANDS_THEN_HALT {
+			m.inputEOF = true
+			m.Halt()
+			return false
+		}
+
+		if m.prompt == PROMPT_ALWAYS || (m.prompt == PROMPT_AUTO && m.inputTTY) {
+			fmt.Fprintf(m.out, "Input: ")
+		}
+		line, err := m.nextLine()
+		if err != nil && len(line) == 0 {
+			m.Error(fmt.Sprintf("Couldn't read input: %v", err))
+			m.inputEOF = err == io.EOF
+			return false
+		}
+
+		line, ok := m.sanitizeLine(line)
+		if !ok {
+			fmt.Fprintf(m.out, "Input must be ASCII; try again.\n")
+			continue
+		}
+		for _, c := range line {
+			m.unused_input = append(m.unused_input, uint16(c))
+		}
+
+		if len(m.unused_input) > 0 {
+			return true
+		}
+	}
+}
+
+// Apply the sanitize mode to line, reporting false if INPUT_REJECT
+// rejects it.
+func (m *Machine) sanitizeLine(line string) (string, bool) {
+	var b strings.Builder
+	for _, c := range line {
+		if c > 127 {
+			switch m.sanitize {
+			case INPUT_STRIP:
+				continue
+			case INPUT_REPLACE:
+				c = '?'
+			case INPUT_REJECT:
+				return "", false
+			}
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String(), true
+}
+
+// SetInputSource replaces the reader that IN consumes input from.
+func (m *Machine) SetInputSource(r io.Reader) {
+	m.input = bufio.NewReader(r)
+	m.inputTTY = isTerminal(r)
+	m.unused_input = m.unused_input[:0]
+}
+
+// Whether to print "Input: " before reading a line.
+const (
+	PROMPT_AUTO   = iota // Default. Only when input comes from a terminal.
+	PROMPT_ALWAYS        // Always.
+	PROMPT_NEVER         // Never.
+)
+
+// SetPrompt chooses when the "Input: " prompt is shown. Piped or
+// scripted input doesn't need it, and it clutters transcripts.
+func (m *Machine) SetPrompt(mode int) {
+	m.prompt = mode
+}
+
+// Report whether r is a terminal. Other character devices, such as
+// /dev/null, aren't.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// A SeededInput is an endless stream of pseudo-random ASCII bytes.
+// Streams created with the same seed are identical, so a run fed by
+// one can be reproduced exactly.
+type SeededInput struct {
+	seed int64
+	rng  *rand.Rand
+}
+
+func NewSeededInput(seed int64) *SeededInput {
+	return &SeededInput{seed: seed, rng: rand.New(rand.NewSource(seed))}
+}
+
+// Seed returns the seed the stream was created with.
+func (s *SeededInput) Seed() int64 {
+	return s.seed
+}
+
+func (s *SeededInput) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(s.rng.Intn(128))
+	}
+
+	return len(p), nil
+}
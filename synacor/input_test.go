@@ -0,0 +1,207 @@
+package synacor
+
+import (
+	"os"
+	"testing"
+)
+
+// Echo the first 20 characters of input.
+const echo20 = `
+		SET r1 0
+loop:	IN r0
+		OUT r0
+		ADD r1 r1 1
+		EQ r2 r1 20
+		JF r2 loop
+		HALT`
+
+func TestSeededInput(t *testing.T) {
+	run := func(seed int64) string {
+		m, out, diag := testMachine(t, echo20, "")
+		in := NewSeededInput(seed)
+		if in.Seed() != seed {
+			t.Errorf("Seed() = %d, want %d", in.Seed(), seed)
+		}
+		m.SetInputSource(in)
+		m.Run()
+
+		if m.State() != HALTED {
+			t.Fatalf("seed %d: state = %s: %s", seed, statesToString[m.State()], diag)
+		}
+		return out.String()
+	}
+
+	a, b := run(42), run(42)
+	if a != b {
+		t.Errorf("same seed consumed %q and %q", a, b)
+	}
+	if len(a) != 20 {
+		t.Errorf("consumed %d characters, want 20", len(a))
+	}
+	for _, c := range a {
+		if c > 127 {
+			t.Errorf("seeded input produced non-ASCII %q", c)
+		}
+	}
+
+	if c := run(43); c == a {
+		t.Errorf("seeds 42 and 43 both consumed %q", a)
+	}
+}
+
+// Echo a line of input.
+const echoLine = `
+loop:	IN r0
+		OUT r0
+		EQ r1 r0 10
+		JF r1 loop
+		HALT`
+
+func TestInputSanitize(t *testing.T) {
+	for _, tc := range []struct {
+		mode int
+		want string
+	}{
+		{INPUT_RAW, "café\n"},
+		{INPUT_STRIP, "caf\n"},
+		{INPUT_REPLACE, "caf?\n"},
+		{INPUT_REJECT, "Input must be ASCII; try again.\ncafe\n"},
+	} {
+		m, out, _ := testMachine(t, echoLine, "café\ncafe\n")
+		m.SetInputSanitize(tc.mode)
+		m.Run()
+
+		if got := out.String(); got != tc.want {
+			t.Errorf("mode %d: output = %q, want %q", tc.mode, got, tc.want)
+		}
+	}
+}
+
+func TestLogInput(t *testing.T) {
+	const src = `
+		IN r0
+		IN m
+		HALT
+m:		.word 0`
+
+	m, _, diag := testMachine(t, src, "\n\n")
+	m.SetLogInput(true)
+	m.Run()
+
+	want := "IN at 0x0000 waiting for input into r0.\nIN at 0x0002 waiting for input into mem[0x0005].\n"
+	if got := diag.String(); got != want {
+		t.Errorf("diag = %q, want %q", got, want)
+	}
+
+	// Queued commands don't wait for anyone.
+	m, _, diag = testMachine(t, src, "")
+	m.SetLogInput(true)
+	m.SetCommands([]string{"", ""})
+	m.Run()
+
+	if !m.Halted() || m.State() != HALTED || diag.Len() != 0 {
+		t.Errorf("with commands, state %d and diag %q, want HALTED with no diag", m.State(), diag.String())
+	}
+}
+
+func TestSetCommands(t *testing.T) {
+	const src = `
+loop:	IN r0
+		OUT r0
+		JMP loop`
+	cmds := []string{"go north", "", "take lamp"}
+
+	m, out, _ := testMachine(t, src, "")
+	m.SetCommands(cmds)
+	m.SetCommandsFallback(COMMANDS_THEN_HALT)
+	m.Run()
+
+	if got, want := out.String(), "go north\n\ntake lamp\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if m.State() != HALTED {
+		t.Errorf("state = %d once commands ran out, want HALTED", m.State())
+	}
+
+	// By default the input source takes over.
+	m, out, _ = testMachine(t, src, "look\n")
+	m.SetCommands(cmds)
+	m.Run()
+
+	if got, want := out.String(), "go north\n\ntake lamp\nlook\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if m.State() != ERROR {
+		t.Errorf("state = %d at the end of input, want ERROR", m.State())
+	}
+}
+
+func TestClearInput(t *testing.T) {
+	m, out, _ := testMachine(t, echoLine, "wrong\nright\n")
+	m.StepN(2)
+	if out.String() != "w" {
+		t.Fatalf("output = %q, want \"w\"", out.String())
+	}
+
+	// The rest of the first line is dropped and the next IN reads the
+	// second.
+	m.ClearInput()
+	if len(m.PendingInput()) != 0 {
+		t.Errorf("pending input = %v after ClearInput, want none", m.PendingInput())
+	}
+	m.Run()
+	if got, want := out.String(), "wright\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	for _, tc := range []struct {
+		mode int
+		want string
+	}{
+		{PROMPT_AUTO, "hi\n"},
+		{PROMPT_ALWAYS, "Input: hi\n"},
+		{PROMPT_NEVER, "hi\n"},
+	} {
+		m, out, _ := testMachine(t, echoLine, "hi\n")
+		m.SetPrompt(tc.mode)
+		m.Run()
+
+		if got := out.String(); got != tc.want {
+			t.Errorf("mode %d: output = %q, want %q", tc.mode, got, tc.want)
+		}
+	}
+
+	// A pipe is a file, but not a terminal.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.WriteString("hi\n")
+	w.Close()
+
+	m, out, _ := testMachine(t, echoLine, "")
+	m.SetInputSource(r)
+	m.Run()
+	if got := out.String(); got != "hi\n" {
+		t.Errorf("reading a pipe, output = %q, want \"hi\\n\"", got)
+	}
+}
+
+func TestPromptDevNull(t *testing.T) {
+	// A character device, but not a terminal.
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer f.Close()
+
+	m, out, _ := testMachine(t, "IN r0\nHALT", "")
+	m.SetInputSource(f)
+	m.Run()
+	if out.String() != "" {
+		t.Errorf("reading %s, output = %q, want no prompt", os.DevNull, out.String())
+	}
+}
@@ -0,0 +1,107 @@
+package synacor
+
+import "fmt"
+
+// LOOP_STACK_WORDS bounds how much of the top of the stack is folded
+// into the state compared by the loop detector.
+const LOOP_STACK_WORDS = 64
+
+// A LoopError describes a machine that returned to an earlier state.
+type LoopError struct {
+	PC    uint16        // Address the machine returned to
+	Regs  [NREGS]uint16 // Register values at PC
+	Depth int           // Stack depth at PC
+	Since int           // Step at which the state was first seen
+}
+
+func (e *LoopError) Error() string {
+	return fmt.Sprintf("Infinite loop at 0x%04x: state unchanged since step %d (regs %v, stack depth %d).", e.PC, e.Since, e.Regs, e.Depth)
+}
+
+type loopState struct {
+	pc        uint16
+	regs      [NREGS]uint16
+	depth     int
+	stackHash uint64 // FNV-1a of the top LOOP_STACK_WORDS stack values
+	memWrites uint64 // Any memory write makes the state distinct
+	inputRead uint64 // As does consuming input
+}
+
+func hashStack(data []uint16) uint64 {
+	if len(data) > LOOP_STACK_WORDS {
+		data = data[len(data)-LOOP_STACK_WORDS:]
+	}
+
+	h := uint64(14695981039346656037)
+	for _, v := range data {
+		h ^= uint64(v)
+		h *= 1099511628211
+	}
+
+	return h
+}
+
+type loopDetector struct {
+	window  int
+	handler func(*LoopError)
+	seen    map[loopState]int // State to the step it was seen at
+	started int               // Step at which seen was last reset
+}
+
+// DetectLoops watches for the machine reaching the same program
+// counter with identical registers and stack, and no memory written or
+// input consumed, within window steps of the first visit. That's a
+// strong sign it will spin forever. On detection fn is called; if fn
+// is nil the machine halts in error instead. A window of zero disables
+// detection.
+func (m *Machine) DetectLoops(window int, fn func(*LoopError)) {
+	if window <= 0 {
+		m.loops = nil
+		return
+	}
+
+	m.loops = &loopDetector{
+		window:  window,
+		handler: fn,
+		seen:    make(map[loopState]int, window),
+		started: m.steps,
+	}
+}
+
+// Record the machine's current state, reporting whether it was seen
+// already within the window.
+func (d *loopDetector) check(m *Machine) bool {
+	// Forget old states so memory use stays bounded.
+	if m.steps-d.started >= d.window {
+		d.seen = make(map[loopState]int, d.window)
+		d.started = m.steps
+	}
+
+	s := loopState{
+		pc:        m.pc,
+		depth:     m.stack.Len(),
+		stackHash: hashStack(m.stack.data),
+		memWrites: m.memWrites,
+		inputRead: m.inputRead,
+	}
+	copy(s.regs[:], m.regs)
+
+	first, ok := d.seen[s]
+	if !ok {
+		d.seen[s] = m.steps
+		return false
+	}
+
+	err := &LoopError{PC: s.pc, Regs: s.regs, Depth: s.depth, Since: first}
+	if d.handler != nil {
+		d.handler(err)
+	} else {
+		m.Error(err.Error())
+	}
+
+	// Don't report the same loop again until it's seen afresh.
+	d.seen = make(map[loopState]int, d.window)
+	d.started = m.steps
+
+	return true
+}
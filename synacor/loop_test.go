@@ -0,0 +1,115 @@
+package synacor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDetectLoops(t *testing.T) {
+	const src = `
+		SET r0 1
+loop:	JMP loop`
+
+	m, _, diag := testMachine(t, src, "")
+	m.DetectLoops(100, nil)
+	m.Run()
+
+	if m.State() != ERROR {
+		t.Fatalf("state = %s, want ERROR", statesToString[m.State()])
+	}
+	if want := "Infinite loop at 0x0003"; !strings.Contains(diag.String(), want) {
+		t.Errorf("diag = %q, want it to contain %q", diag, want)
+	}
+
+	var found *LoopError
+	m, _, _ = testMachine(t, src, "")
+	m.DetectLoops(100, func(e *LoopError) {
+		if found == nil {
+			found = e
+		}
+		m.Halt()
+	})
+	m.Run()
+
+	if found == nil {
+		t.Fatal("handler wasn't called")
+	}
+	if found.PC != 3 || found.Regs[0] != 1 || found.Depth != 0 {
+		t.Errorf("LoopError = %+v, want PC 0x0003, r0 1, depth 0", found)
+	}
+}
+
+func TestDetectLoopsNotFooled(t *testing.T) {
+	for _, tc := range []struct {
+		name, src, input string
+	}{
+		{"counting", countLoop, ""},
+		{"reading input", `
+loop:	IN r0
+		EQ r1 r0 10
+		JF r1 loop
+		HALT`, "aa\n"},
+		{"writing memory", `
+loop:	RMEM r1 100
+		ADD r1 r1 1
+		WMEM 100 r1
+		EQ r2 r1 50
+		JT r2 done
+		SET r1 0
+		SET r2 0
+		JMP loop
+done:	HALT`, ""},
+	} {
+		m, _, diag := testMachine(t, tc.src, tc.input)
+		m.DetectLoops(1000, nil)
+		m.Run()
+
+		if m.State() != HALTED {
+			t.Errorf("%s: state = %s, want HALTED: %s", tc.name, statesToString[m.State()], diag)
+		}
+	}
+}
+
+func TestDetectLoopsAfterRestore(t *testing.T) {
+	// Same registers and program counter, different program.
+	var snap bytes.Buffer
+	o, _, _ := testMachine(t, "OUT 'b'\nHALT", "")
+	if err := o.Snapshot(&snap); err != nil {
+		t.Fatal(err)
+	}
+
+	m, out, _ := testMachine(t, "OUT 'a'\nHALT", "")
+	m.DetectLoops(100, nil)
+	m.Step()
+	if err := m.Restore(&snap); err != nil {
+		t.Fatal(err)
+	}
+	m.Run()
+
+	if m.State() != HALTED || out.String() != "ab" {
+		t.Errorf("after Restore, state %s with output %q, want HALTED with \"ab\"", statesToString[m.State()], out.String())
+	}
+
+	// Stacks that differ only below what the detector hashes.
+	deep := func(bottom uint16) []byte {
+		n := NewMachine(nil)
+		n.stack.Push(bottom)
+		for i := 0; i < LOOP_STACK_WORDS; i++ {
+			n.stack.Push(1)
+		}
+		return n.StackBytes()
+	}
+
+	m, _, _ = testMachine(t, "NOOP\nHALT", "")
+	m.DetectLoops(100, nil)
+	m.LoadStack(deep(1))
+	m.Step()
+	m.SetPC(0)
+	m.LoadStack(deep(2))
+	m.Run()
+
+	if m.State() != HALTED {
+		t.Errorf("after LoadStack, state %s, want HALTED", statesToString[m.State()])
+	}
+}
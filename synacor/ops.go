@@ -0,0 +1,42 @@
+package synacor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Handlers for opcodes added with RegisterOp.
+var customOps map[uint16]func(m *Machine, args []uint16) = make(map[uint16]func(m *Machine, args []uint16))
+
+// RegisterOp adds an instruction to the instruction set, for modified
+// binaries that use opcodes beyond the standard ones. When executed, fn
+// is called with the argc raw operand words that follow the opcode,
+// after which the program counter moves past them as usual. The new
+// instruction is also known to the decoder, disassembler and assembler
+// by name. Register any instructions before creating machines; the
+// registry is shared and not safe for concurrent use.
+func RegisterOp(op uint16, argc uint16, name string, fn func(m *Machine, args []uint16)) error {
+	if _, ok := opsToString[int(op)]; ok {
+		return fmt.Errorf("opcode %d is already %s", op, opsToString[int(op)])
+	}
+
+	name = strings.ToUpper(name)
+	if !isIdent(name) {
+		return fmt.Errorf("invalid instruction name %q", name)
+	}
+
+	if other, ok := opsFromString[name]; ok {
+		return fmt.Errorf("instruction name %s is already opcode %d", name, other)
+	}
+
+	if fn == nil {
+		return fmt.Errorf("no handler for opcode %d", op)
+	}
+
+	opsToString[int(op)] = name
+	opsFromString[name] = op
+	argsForOp[int(op)] = argc
+	customOps[op] = fn
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package synacor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegisterOp(t *testing.T) {
+	// Stores its second operand plus one in its first.
+	incr := func(m *Machine, args []uint16) {
+		m.setReg(args[0], mask15(m.readArg(args[1])+1))
+	}
+	if err := RegisterOp(100, 2, "incr", incr); err != nil {
+		t.Fatalf("RegisterOp: %v", err)
+	}
+	t.Cleanup(func() {
+		delete(opsToString, 100)
+		delete(opsFromString, "INCR")
+		delete(argsForOp, 100)
+		delete(customOps, 100)
+	})
+
+	for _, tc := range []struct {
+		op   uint16
+		name string
+		want string
+	}{
+		{ADD, "plus", "opcode 9 is already ADD"},
+		{100, "other", "opcode 100 is already INCR"},
+		{101, "Add", "instruction name ADD is already opcode 9"},
+		{101, "two words", `invalid instruction name "TWO WORDS"`},
+	} {
+		if err := RegisterOp(tc.op, 1, tc.name, incr); err == nil || err.Error() != tc.want {
+			t.Errorf("RegisterOp(%d, %q) = %v, want %q", tc.op, tc.name, err, tc.want)
+		}
+	}
+
+	m, _, _ := testMachine(t, `
+		SET r0 41
+		INCR r1 r0
+		HALT`, "")
+	m.EnableTimings()
+	m.Run()
+
+	if !m.Halted() || m.State() != HALTED || m.Register(1) != 42 {
+		t.Errorf("state %d with r1 = %d, want HALTED with 42", m.State(), m.Register(1))
+	}
+	if _, ok := m.Timings()["INCR"]; !ok {
+		t.Errorf("Timings() = %v, want an entry for INCR", m.Timings())
+	}
+
+	var b strings.Builder
+	m.Disassemble(&b, 3, 6)
+	if want := "0x0003: INCR r1 r0\n"; b.String() != want {
+		t.Errorf("Disassemble = %q, want %q", b.String(), want)
+	}
+}
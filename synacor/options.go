@@ -0,0 +1,44 @@
+package synacor
+
+import (
+	"fmt"
+	"io"
+)
+
+// An Option configures a Machine at construction.
+type Option func(m *Machine)
+
+// WithInput reads the program's input from r.
+func WithInput(r io.Reader) Option {
+	return func(m *Machine) { m.SetInputSource(r) }
+}
+
+// WithOutput writes the program's output to w.
+func WithOutput(w io.Writer) Option {
+	return func(m *Machine) { m.SetOutput(w) }
+}
+
+// WithDiag writes machine errors and diagnostics to w.
+func WithDiag(w io.Writer) Option {
+	return func(m *Machine) { m.SetDiag(w) }
+}
+
+// WithStepLimit errors once n instructions have executed.
+func WithStepLimit(n int) Option {
+	return func(m *Machine) { m.SetStepLimit(n) }
+}
+
+// WithTracer writes each instruction executed to w.
+func WithTracer(w io.Writer) Option {
+	return func(m *Machine) { m.SetTracer(w) }
+}
+
+// WithEntry starts execution at addr instead of 0. An address outside
+// memory leaves the machine in error.
+func WithEntry(addr uint16) Option {
+	return func(m *Machine) {
+		if err := m.SetPC(addr); err != nil {
+			m.Error(fmt.Sprintf("Invalid entry point: %v.", err))
+		}
+	}
+}
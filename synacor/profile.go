@@ -0,0 +1,119 @@
+package synacor
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// A HotAddress is an instruction address and how often it executed.
+type HotAddress struct {
+	Addr  uint16
+	Count uint64
+	Inst  string // Disassembly of the instruction there now
+}
+
+// EnableProfile starts counting how many times the instruction at each
+// address executes, for HotAddresses.
+func (m *Machine) EnableProfile() {
+	if m.profile == nil {
+		m.profile = make([]uint64, len(m.memory))
+	}
+}
+
+// HotAddresses returns the top most executed addresses since
+// EnableProfile, most executed first. Ties are broken by address. It
+// returns nil if profiling isn't enabled.
+func (m *Machine) HotAddresses(top int) []HotAddress {
+	if m.profile == nil {
+		return nil
+	}
+
+	hot := make([]HotAddress, 0)
+	for addr, n := range m.profile {
+		if n > 0 {
+			hot = append(hot, HotAddress{Addr: uint16(addr), Count: n})
+		}
+	}
+
+	sort.Slice(hot, func(i, j int) bool {
+		if hot[i].Count != hot[j].Count {
+			return hot[i].Count > hot[j].Count
+		}
+		return hot[i].Addr < hot[j].Addr
+	})
+
+	if top >= 0 && len(hot) > top {
+		hot = hot[:top]
+	}
+
+	for i := range hot {
+		if inst, ok := m.Decode(hot[i].Addr); ok {
+			hot[i].Inst = inst.String()
+		} else {
+			hot[i].Inst = "<invalid>"
+		}
+	}
+
+	return hot
+}
+
+// REPORT_HOT is how many of the hottest addresses Report lists.
+const REPORT_HOT = 10
+
+// Report writes a summary of the run to w: the final state, the number
+// of instructions executed and, for whichever instrumentation is
+// enabled, each opcode's count and time (EnableTimings), and the
+// coverage of the decoded program and the hottest addresses
+// (EnableProfile).
+func (m *Machine) Report(w io.Writer) {
+	state, reason := m.HaltReason()
+	fmt.Fprintf(w, "State: %s", statesToString[state])
+	if reason != "" {
+		fmt.Fprintf(w, " (%s)", reason)
+	}
+	fmt.Fprintf(w, "\nInstructions executed: %d\n", m.steps)
+
+	if m.timings != nil {
+		ops := make([]int, 0, len(m.opCounts))
+		for op, n := range m.opCounts {
+			if n > 0 {
+				ops = append(ops, op)
+			}
+		}
+		sort.Slice(ops, func(i, j int) bool { return opsToString[ops[i]] < opsToString[ops[j]] })
+
+		fmt.Fprintln(w, "\nOpcodes:")
+		for _, op := range ops {
+			fmt.Fprintf(w, "  %-5s %10d %v\n", opsToString[op], m.opCounts[op], m.timings[op])
+		}
+	}
+
+	if m.profile != nil {
+		// Count code that only exists at run time, too, so it can't
+		// push coverage past 100%.
+		code := m.decoded()
+		total := len(code)
+		hit := 0
+		for addr, n := range m.profile {
+			if n == 0 {
+				continue
+			}
+			hit++
+			if _, ok := code[uint16(addr)]; !ok {
+				total++
+			}
+		}
+
+		pct := 0.0
+		if total > 0 {
+			pct = 100 * float64(hit) / float64(total)
+		}
+		fmt.Fprintf(w, "\nCoverage: %d of %d instructions executed (%.1f%%)\n", hit, total, pct)
+
+		fmt.Fprintln(w, "\nHottest addresses:")
+		for _, h := range m.HotAddresses(REPORT_HOT) {
+			fmt.Fprintf(w, "  %10d %s\n", h.Count, h.Inst)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package synacor
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHotAddresses(t *testing.T) {
+	m, _, _ := testMachine(t, countLoop, "")
+	if m.HotAddresses(3) != nil {
+		t.Error("HotAddresses before EnableProfile isn't nil")
+	}
+
+	m.EnableProfile()
+	m.Run()
+
+	// The loop body runs ten times; the lines either side run once.
+	want := []HotAddress{
+		{Addr: 3, Count: 10, Inst: "0x0003: ADD r0 r0 0x0001"},
+		{Addr: 7, Count: 10, Inst: "0x0007: EQ r1 r0 0x000a"},
+		{Addr: 11, Count: 10, Inst: "0x000b: JF r1 0x0003"},
+	}
+	if got := m.HotAddresses(3); !reflect.DeepEqual(got, want) {
+		t.Errorf("HotAddresses(3) = %v, want %v", got, want)
+	}
+
+	all := m.HotAddresses(-1)
+	if len(all) != 5 || all[3].Addr != 0 || all[3].Count != 1 || all[4].Addr != 14 {
+		t.Errorf("HotAddresses(-1) = %v, want the loop then 0x0000 and 0x000e once each", all)
+	}
+}
+
+func TestReport(t *testing.T) {
+	m, _, _ := testMachine(t, `
+		SET r0 1
+		JF r0 skip
+		HALT
+skip:	OUT 'x'
+		HALT`, "")
+
+	var b strings.Builder
+	m.Report(&b)
+	if want := "State: RUNNING\nInstructions executed: 0\n"; b.String() != want {
+		t.Errorf("Report before running =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	m.EnableTimings()
+	m.EnableProfile()
+	m.Run()
+	b.Reset()
+	m.Report(&b)
+
+	// Timings vary, so match them loosely.
+	want := regexp.MustCompile(`^State: HALTED \(Halted at 0x0006\.\)
+Instructions executed: 3
+
+Opcodes:
+  HALT           1 \S+
+  JF             1 \S+
+  SET            1 \S+
+
+Coverage: 3 of 5 instructions executed \(60\.0%\)
+
+Hottest addresses:
+           1 0x0000: SET r0 0x0001
+           1 0x0003: JF r0 0x0007
+           1 0x0006: HALT
+$`)
+	if !want.MatchString(b.String()) {
+		t.Errorf("Report =\n%s\nwant it to match\n%s", b.String(), want)
+	}
+}
+
+func TestReportCoverage(t *testing.T) {
+	// Nothing reaches f statically, the NOOP only exists once the
+	// program writes it, and the OUT never runs.
+	m, _, _ := testMachine(t, `
+		SET r0 f
+		CALL r0
+		WMEM slot 21
+slot:	.word 30000
+		HALT
+f:		ADD r1 1 1
+		RET
+		OUT 'x'`, "")
+	m.EnableProfile()
+	m.Run()
+
+	var b strings.Builder
+	m.Report(&b)
+	if want := "Coverage: 7 of 8 instructions executed (87.5%)\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("Report =\n%s\nwant it to contain %q", b.String(), want)
+	}
+}
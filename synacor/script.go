@@ -0,0 +1,111 @@
+package synacor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RunInputScript plays a script that automates a session with the
+// program. Each line is one of:
+//
+//	# comment          ignored, as are blank lines
+//	@wait "text"       run until the program prints text
+//	@snapshot path     save the machine's state to path
+//	@set rN value      set a register
+//	@@text             the input line "@text"
+//	anything else      a line of input for the program
+//
+// Directives may be indented. Input lines are sanitized like input
+// from the input source, then queued to be consumed as the program
+// reads, so a command is normally followed by an @wait for its
+// response. The machine is left wherever the last directive stopped
+// it. The first failing line stops the script with an error naming it.
+func (m *Machine) RunInputScript(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		if err := m.scriptLine(scanner.Text()); err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (m *Machine) scriptLine(line string) error {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		return nil
+	case strings.HasPrefix(trimmed, "@@"):
+		return m.queueLine(trimmed[1:])
+	case !strings.HasPrefix(trimmed, "@"):
+		return m.queueLine(line)
+	}
+
+	directive, arg, _ := strings.Cut(trimmed, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch directive {
+	case "@wait":
+		text, err := strconv.Unquote(arg)
+		if err != nil {
+			return fmt.Errorf("@wait needs quoted text, got %s", arg)
+		}
+
+		if _, err := m.RunUntilOutput(regexp.MustCompile(regexp.QuoteMeta(text))); err != nil {
+			return fmt.Errorf("waiting for %q: %v", text, err)
+		}
+		return nil
+	case "@snapshot":
+		if arg == "" {
+			return fmt.Errorf("@snapshot needs a path")
+		}
+		return m.SaveState(arg)
+	case "@set":
+		fields := strings.Fields(arg)
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: @set rN value")
+		}
+
+		r, ok := regName(fields[0])
+		if !ok {
+			return fmt.Errorf("invalid register %q", fields[0])
+		}
+
+		v, err := strconv.ParseUint(fields[1], 0, 16)
+		if err != nil {
+			return fmt.Errorf("invalid value %q", fields[1])
+		}
+
+		return m.SetRegister(int(r), uint16(v))
+	}
+
+	return fmt.Errorf("unknown directive %q", directive)
+}
+
+// Queue line, with a newline added, as input for IN. Any line ending
+// it already has is dropped. Non-ASCII characters are treated as for
+// input read from the input source, except that a rejected line is an
+// error.
+func (m *Machine) queueLine(line string) error {
+	line, ok := m.sanitizeLine(strings.TrimRight(line, "\r\n"))
+	if !ok {
+		return fmt.Errorf("input must be ASCII")
+	}
+
+	chars := make([]uint16, 0, len(line)+1)
+	for _, c := range line {
+		if c > MAX_15BIT {
+			return fmt.Errorf("character %q doesn't fit in 15 bits", c)
+		}
+		chars = append(chars, uint16(c))
+	}
+
+	m.unused_input = append(m.unused_input, append(chars, '\n')...)
+	return nil
+}
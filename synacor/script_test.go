@@ -0,0 +1,90 @@
+package synacor
+
+import (
+	"strings"
+	"testing"
+)
+
+// Prompt with '>' and echo each line, marking each character with a
+// '*' once r7 is set.
+const promptLoop = `
+loop:	OUT '>'
+		OUT 10
+read:	IN r0
+		JF r7 plain
+		OUT '*'
+plain:	OUT r0
+		EQ r1 r0 10
+		JF r1 read
+		JMP loop`
+
+func TestRunInputScript(t *testing.T) {
+	m, out, _ := testMachine(t, promptLoop, "")
+
+	script := `
+# Answer the first prompt plainly.
+@wait ">"
+go
+@wait ">"
+@set r7 1
+@@at
+@wait ">"`
+	if err := m.RunInputScript(strings.NewReader(script)); err != nil {
+		t.Fatalf("RunInputScript: %v", err)
+	}
+
+	if got, want := out.String(), ">\ngo\n>\n*@*a*t*\n>\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if m.State() != RUNNING || !m.needsInput() {
+		t.Errorf("state %d at 0x%04x, want RUNNING waiting for input", m.State(), m.PC())
+	}
+}
+
+func TestRunInputScriptErrors(t *testing.T) {
+	for _, tc := range []struct {
+		script string
+		want   string
+	}{
+		{"@wait >", "line 1: @wait needs quoted text, got >"},
+		{"\n@wait \">\"\n@wait \"never\"", `line 3: waiting for "never": waiting for input`},
+		{"@set r8 1", `line 1: invalid register "r8"`},
+		{"@set r0 40000", "line 1: value 40000 is out of range 0..32767"},
+		{"@set r0", "line 1: usage: @set rN value"},
+		{"@snapshot", "line 1: @snapshot needs a path"},
+		{"look\n@jump 5", `line 2: unknown directive "@jump"`},
+	} {
+		m, _, _ := testMachine(t, promptLoop, "")
+		err := m.RunInputScript(strings.NewReader(tc.script))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("RunInputScript(%q) = %v, want %q", tc.script, err, tc.want)
+		}
+	}
+}
+
+func TestRunInputScriptIndentAndSanitize(t *testing.T) {
+	m, out, _ := testMachine(t, promptLoop, "")
+	m.SetInputSanitize(INPUT_REPLACE)
+
+	script := `
+  @wait ">"
+	café
+	@wait ">"
+  @@at
+  @wait ">"`
+	if err := m.RunInputScript(strings.NewReader(script)); err != nil {
+		t.Fatalf("RunInputScript: %v", err)
+	}
+
+	// Indented input keeps its indentation.
+	if got, want := out.String(), ">\n\tcaf?\n>\n@at\n>\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	m, _, _ = testMachine(t, promptLoop, "")
+	m.SetInputSanitize(INPUT_REJECT)
+	err := m.RunInputScript(strings.NewReader("look\ncafé"))
+	if want := "line 2: input must be ASCII"; err == nil || err.Error() != want {
+		t.Errorf("RunInputScript rejecting = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,231 @@
+package synacor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// SERVER_RUN_LIMIT bounds the instructions a single continue request
+// executes, so a program that never stops can't wedge the server.
+const SERVER_RUN_LIMIT = 10000000
+
+// SERVER_INPUT_LIMIT is the most input, in bytes, accepted at once.
+const SERVER_INPUT_LIMIT = 4096
+
+// SERVER_DISASM_LIMIT is the most lines a disasm request returns.
+const SERVER_DISASM_LIMIT = 1000
+
+// A Server exposes a Machine over HTTP as a JSON control API. All
+// endpoints live under /api/:
+//
+//	GET  /api/state                   registers, stack, output and more
+//	GET  /api/disasm?addr=A&count=N   N lines of disassembly from A
+//	POST /api/step?n=N                execute N instructions (default 1)
+//	POST /api/continue                run until a breakpoint, halt or IN
+//	POST /api/break?addr=A            set a breakpoint at A
+//	POST /api/clear?addr=A            clear the breakpoint at A
+//	POST /api/input                   queue the body as a line of input
+//
+// Execution stops, rather than blocking, when the program wants input
+// that hasn't been queued. Every POST responds with the new state.
+// Counts are capped at SERVER_DISASM_LIMIT lines and SERVER_RUN_LIMIT
+// steps, and input goes through the same sanitizing as stdin.
+type Server struct {
+	mu  sync.Mutex
+	m   *Machine
+	mux *http.ServeMux
+}
+
+// NewServer returns a Server controlling m. It turns on output capture
+// so the program's output can be reported.
+func NewServer(m *Machine) *Server {
+	m.EnableOutputCapture()
+
+	s := &Server{m: m, mux: http.NewServeMux()}
+	s.handle("/api/state", http.MethodGet, (*Server).state)
+	s.handle("/api/disasm", http.MethodGet, (*Server).disasm)
+	s.handle("/api/step", http.MethodPost, (*Server).step)
+	s.handle("/api/continue", http.MethodPost, (*Server).cont)
+	s.handle("/api/break", http.MethodPost, (*Server).setBreak)
+	s.handle("/api/clear", http.MethodPost, (*Server).clearBreak)
+	s.handle("/api/input", http.MethodPost, (*Server).input)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Register fn at path for method. Handlers run with the machine locked
+// and return the value to encode as the JSON response.
+func (s *Server) handle(path, method string, fn func(*Server, *http.Request) (any, error)) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, fmt.Sprintf("%s requires %s", path, method), http.StatusMethodNotAllowed)
+			return
+		}
+
+		v, err := s.call(fn, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	})
+}
+
+// Run fn with the machine locked.
+func (s *Server) call(fn func(*Server, *http.Request) (any, error), r *http.Request) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return fn(s, r)
+}
+
+// The machine as reported by /api/state.
+type serverState struct {
+	PC              uint16   `json:"pc"`
+	State           string   `json:"state"`
+	Steps           int      `json:"steps"`
+	Regs            []uint16 `json:"regs"`
+	Stack           []uint16 `json:"stack"`
+	Breakpoints     []uint16 `json:"breakpoints"`
+	AtBreakpoint    bool     `json:"at_breakpoint"`
+	WaitingForInput bool     `json:"waiting_for_input"`
+	Instruction     string   `json:"instruction"`
+	Output          string   `json:"output"`
+}
+
+// A line of disassembly as reported by /api/disasm.
+type serverLine struct {
+	Addr uint16 `json:"addr"`
+	Text string `json:"text"`
+}
+
+func (s *Server) state(r *http.Request) (any, error) {
+	m := s.m
+
+	bps := m.Breakpoints()
+	sort.Slice(bps, func(i, j int) bool { return bps[i] < bps[j] })
+
+	st := serverState{
+		PC:              m.pc,
+		State:           statesToString[m.state],
+		Steps:           m.steps,
+		Regs:            append([]uint16{}, m.regs...),
+		Stack:           append([]uint16{}, m.stack.data...),
+		Breakpoints:     bps,
+		AtBreakpoint:    m.atBreak,
+		WaitingForInput: m.needsInput(),
+		Instruction:     fmt.Sprintf("0x%04x: <invalid>", m.pc),
+		Output:          m.Output(),
+	}
+	if inst, ok := m.Decode(m.pc); ok {
+		st.Instruction = inst.String()
+	}
+
+	return st, nil
+}
+
+func (s *Server) disasm(r *http.Request) (any, error) {
+	addr, count := s.m.pc, 16
+	if a := r.FormValue("addr"); a != "" {
+		var err error
+		if addr, err = parseAddr(a); err != nil {
+			return nil, err
+		}
+	}
+	if c := r.FormValue("count"); c != "" {
+		n, err := strconv.Atoi(c)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid count %q", c)
+		}
+		count = n
+	}
+	if count > SERVER_DISASM_LIMIT {
+		count = SERVER_DISASM_LIMIT
+	}
+
+	lines := make([]serverLine, 0)
+	for next := s.m.Instructions(addr); len(lines) < count; {
+		inst, ok := next()
+		if !ok {
+			break
+		}
+		lines = append(lines, serverLine{Addr: inst.Addr, Text: inst.String()})
+	}
+
+	return lines, nil
+}
+
+// Execute up to n instructions, stopping early at a breakpoint, on
+// halting, or before an IN that would block.
+func (s *Server) run(n int) {
+	for i := 0; i < n && !s.m.needsInput(); i++ {
+		if s.m.StepN(1) != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) step(r *http.Request) (any, error) {
+	n := 1
+	if v := r.FormValue("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid step count %q", v)
+		}
+	}
+	if n > SERVER_RUN_LIMIT {
+		n = SERVER_RUN_LIMIT
+	}
+
+	s.run(n)
+	return s.state(r)
+}
+
+func (s *Server) cont(r *http.Request) (any, error) {
+	s.run(SERVER_RUN_LIMIT)
+	return s.state(r)
+}
+
+func (s *Server) setBreak(r *http.Request) (any, error) {
+	addr, err := parseAddr(r.FormValue("addr"))
+	if err != nil {
+		return nil, err
+	}
+
+	s.m.SetBreakpoint(addr)
+	return s.state(r)
+}
+
+func (s *Server) clearBreak(r *http.Request) (any, error) {
+	addr, err := parseAddr(r.FormValue("addr"))
+	if err != nil {
+		return nil, err
+	}
+
+	s.m.ClearBreakpoint(addr)
+	return s.state(r)
+}
+
+func (s *Server) input(r *http.Request) (any, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, SERVER_INPUT_LIMIT))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.m.queueLine(string(body)); err != nil {
+		return nil, err
+	}
+
+	return s.state(r)
+}
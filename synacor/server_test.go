@@ -0,0 +1,184 @@
+package synacor
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Make a request of srv, returning the status code and the body.
+func request(t *testing.T, srv *httptest.Server, method, path, body string) (int, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, b
+}
+
+// Make a request of srv that must succeed, decoding the response into v.
+func requestJSON(t *testing.T, srv *httptest.Server, method, path, body string, v any) {
+	t.Helper()
+
+	code, b := request(t, srv, method, path, body)
+	if code != http.StatusOK {
+		t.Fatalf("%s %s: %d: %s", method, path, code, b)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatalf("%s %s: decoding response: %v", method, path, err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	m, _, _ := testMachine(t, "OUT '>'\n"+echoLine, "")
+	srv := httptest.NewServer(NewServer(m))
+	defer srv.Close()
+
+	// Make a request and decode the state it responds with.
+	call := func(method, path, body string) serverState {
+		t.Helper()
+
+		var st serverState
+		requestJSON(t, srv, method, path, body, &st)
+		return st
+	}
+
+	st := call(http.MethodGet, "/api/state", "")
+	if st.PC != 0 || st.State != "RUNNING" || st.Instruction != "0x0000: OUT 0x003e" || len(st.Regs) != NREGS {
+		t.Errorf("initial state = %+v", st)
+	}
+
+	st = call(http.MethodPost, "/api/step", "")
+	if st.PC != 2 || st.Steps != 1 || st.Output != ">" || !st.WaitingForInput {
+		t.Errorf("after a step, state = %+v", st)
+	}
+
+	// Stepping doesn't block when there's no input to be had.
+	st = call(http.MethodPost, "/api/step?n=5", "")
+	if st.PC != 2 || st.Steps != 1 {
+		t.Errorf("stepping at IN without input, state = %+v", st)
+	}
+
+	st = call(http.MethodPost, "/api/input", "hi")
+	if st.WaitingForInput {
+		t.Errorf("after input, state = %+v", st)
+	}
+	st = call(http.MethodPost, "/api/continue", "")
+	if st.State != "HALTED" || st.Output != ">hi\n" {
+		t.Errorf("after continuing, state = %+v", st)
+	}
+
+	if code, _ := request(t, srv, http.MethodGet, "/api/step", ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /api/step: %d, want 405", code)
+	}
+}
+
+func TestServerDisasm(t *testing.T) {
+	m, _, _ := testMachine(t, countLoop, "")
+	srv := httptest.NewServer(NewServer(m))
+	defer srv.Close()
+
+	var lines []serverLine
+	requestJSON(t, srv, http.MethodGet, "/api/disasm?addr=3&count=2", "", &lines)
+	want := []serverLine{{3, "0x0003: ADD r0 r0 0x0001"}, {7, "0x0007: EQ r1 r0 0x000a"}}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("disasm = %v, want %v", lines, want)
+	}
+
+	// Far too many lines are cut down rather than allocated.
+	requestJSON(t, srv, http.MethodGet, "/api/disasm?count=1000000000000", "", &lines)
+	if len(lines) != SERVER_DISASM_LIMIT {
+		t.Errorf("disasm with a huge count returned %d lines, want %d", len(lines), SERVER_DISASM_LIMIT)
+	}
+
+	for _, path := range []string{"/api/disasm?count=0", "/api/disasm?count=x", "/api/disasm?addr=40000"} {
+		if code, _ := request(t, srv, http.MethodGet, path, ""); code != http.StatusBadRequest {
+			t.Errorf("GET %s: %d, want 400", path, code)
+		}
+	}
+}
+
+func TestServerBreakpoints(t *testing.T) {
+	m, _, _ := testMachine(t, countLoop, "")
+	srv := httptest.NewServer(NewServer(m))
+	defer srv.Close()
+
+	var st serverState
+	requestJSON(t, srv, http.MethodPost, "/api/break?addr=0x0007", "", &st)
+	if !reflect.DeepEqual(st.Breakpoints, []uint16{7}) {
+		t.Errorf("breakpoints = %v, want [7]", st.Breakpoints)
+	}
+
+	requestJSON(t, srv, http.MethodPost, "/api/continue", "", &st)
+	if st.PC != 7 || !st.AtBreakpoint {
+		t.Errorf("after continuing, state = %+v, want at the breakpoint at 7", st)
+	}
+
+	requestJSON(t, srv, http.MethodPost, "/api/clear?addr=7", "", &st)
+	if len(st.Breakpoints) != 0 {
+		t.Errorf("after clearing, breakpoints = %v, want none", st.Breakpoints)
+	}
+	requestJSON(t, srv, http.MethodPost, "/api/continue", "", &st)
+	if st.State != "HALTED" || st.Regs[0] != 10 {
+		t.Errorf("after continuing, state = %+v, want HALTED with r0 = 10", st)
+	}
+
+	for _, path := range []string{"/api/break", "/api/break?addr=x", "/api/clear?addr=40000"} {
+		if code, _ := request(t, srv, http.MethodPost, path, ""); code != http.StatusBadRequest {
+			t.Errorf("POST %s: %d, want 400", path, code)
+		}
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	m, _, _ := testMachine(t, "spin: JMP spin", "")
+	m.SetStepLimit(0)
+	srv := httptest.NewServer(NewServer(m))
+	defer srv.Close()
+
+	var st serverState
+	requestJSON(t, srv, http.MethodPost, "/api/step?n=1000000000000", "", &st)
+	if st.Steps != SERVER_RUN_LIMIT {
+		t.Errorf("stepping a huge count ran %d steps, want %d", st.Steps, SERVER_RUN_LIMIT)
+	}
+
+	for _, path := range []string{"/api/step?n=0", "/api/step?n=-1", "/api/step?n=x"} {
+		if code, _ := request(t, srv, http.MethodPost, path, ""); code != http.StatusBadRequest {
+			t.Errorf("POST %s: %d, want 400", path, code)
+		}
+	}
+}
+
+func TestServerInputSanitize(t *testing.T) {
+	m, out, _ := testMachine(t, echoLine, "")
+	m.SetInputSanitize(INPUT_REJECT)
+	srv := httptest.NewServer(NewServer(m))
+	defer srv.Close()
+
+	if code, body := request(t, srv, http.MethodPost, "/api/input", "café"); code != http.StatusBadRequest {
+		t.Errorf("POST /api/input with non-ASCII: %d %s, want 400", code, body)
+	}
+
+	m.SetInputSanitize(INPUT_REPLACE)
+	var st serverState
+	requestJSON(t, srv, http.MethodPost, "/api/input", "café", &st)
+	requestJSON(t, srv, http.MethodPost, "/api/continue", "", &st)
+	if got, want := out.String(), "caf?\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,311 @@
+package synacor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	SNAPSHOT_MAGIC     = "SYNS"
+	SNAPSHOT_VERSION   = 2
+	SNAPSHOT_MAX_STACK = 1 << 24 // Sanity bound when restoring
+	SNAPSHOT_MAX_INPUT = 1 << 24 // Likewise
+)
+
+// The fixed-size leading part of a snapshot. It's followed by the
+// memory image, then the stack, bottom first, and then (since version
+// 2) a 32-bit count and the input read but not yet consumed by IN.
+type snapshotHeader struct {
+	Magic    [4]byte
+	Version  uint16
+	PC       uint16
+	State    uint16
+	Regs     [NREGS]uint16
+	MemWords uint32
+	StackLen uint32
+}
+
+// Snapshot writes the machine's memory, registers, stack, program
+// counter, state and buffered input to w. A machine that stopped only
+// because its input ran out is recorded as running, so restoring it
+// resumes at the IN that wanted more.
+func (m *Machine) Snapshot(w io.Writer) error {
+	h := snapshotHeader{
+		Version:  SNAPSHOT_VERSION,
+		PC:       m.pc,
+		State:    uint16(m.state),
+		MemWords: uint32(len(m.memory)),
+		StackLen: uint32(m.stack.Len()),
+	}
+	copy(h.Magic[:], SNAPSHOT_MAGIC)
+	copy(h.Regs[:], m.regs)
+
+	if m.inputEOF {
+		h.State = RUNNING
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, v := range []any{h, m.memory, m.stack.data, uint32(len(m.unused_input)), m.unused_input} {
+		if err := binary.Write(bw, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Restore replaces the machine's state with one written by Snapshot.
+// The machine is left untouched if the snapshot can't be read. Version
+// 1 snapshots, which didn't record buffered input, restore with none.
+func (m *Machine) Restore(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	var h snapshotHeader
+	if err := binary.Read(br, binary.LittleEndian, &h); err != nil {
+		return fmt.Errorf("couldn't read snapshot header: %v", err)
+	}
+
+	if string(h.Magic[:]) != SNAPSHOT_MAGIC {
+		return fmt.Errorf("not a snapshot")
+	}
+
+	if h.Version < 1 || h.Version > SNAPSHOT_VERSION {
+		return fmt.Errorf("snapshot is version %d, expected at most version %d", h.Version, SNAPSHOT_VERSION)
+	}
+
+	if int(h.MemWords) != len(m.memory) {
+		return fmt.Errorf("snapshot has %d words of memory, expected %d", h.MemWords, len(m.memory))
+	}
+
+	if h.State > ERROR {
+		return fmt.Errorf("snapshot has invalid state %d", h.State)
+	}
+
+	mem := make([]uint16, len(m.memory))
+	if err := binary.Read(br, binary.LittleEndian, mem); err != nil {
+		return fmt.Errorf("couldn't read snapshot memory: %v", err)
+	}
+
+	if h.StackLen > SNAPSHOT_MAX_STACK {
+		return fmt.Errorf("snapshot stack of %d values is implausibly deep", h.StackLen)
+	}
+
+	stack := make([]uint16, h.StackLen)
+	if err := binary.Read(br, binary.LittleEndian, stack); err != nil {
+		return fmt.Errorf("couldn't read snapshot stack: %v", err)
+	}
+
+	input := []uint16{}
+	if h.Version >= 2 {
+		var n uint32
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return fmt.Errorf("couldn't read snapshot input: %v", err)
+		}
+
+		if n > SNAPSHOT_MAX_INPUT {
+			return fmt.Errorf("snapshot input of %d characters is implausibly long", n)
+		}
+
+		input = make([]uint16, n)
+		if err := binary.Read(br, binary.LittleEndian, input); err != nil {
+			return fmt.Errorf("couldn't read snapshot input: %v", err)
+		}
+	}
+
+	m.memory = mem
+	copy(m.regs, h.Regs[:])
+	m.pc = h.PC
+	m.state = int(h.State)
+	m.haltReason = ""
+	m.unused_input = input
+	m.inputEOF = false
+	m.atBreak = false
+	m.calls = m.calls[:0]
+
+	m.stack.data = stack
+	if m.stack.tags != nil {
+		m.stack.tags = make([]int, len(stack))
+	}
+
+	// The loop detector must not mistake the restored machine for the
+	// one it replaced.
+	m.memWrites++
+
+	return nil
+}
+
+// SaveState writes a snapshot of the machine to the file at path.
+func (m *Machine) SaveState(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := m.Snapshot(f); err != nil {
+		f.Close()
+		return fmt.Errorf("couldn't save state to %q: %v", path, err)
+	}
+
+	return f.Close()
+}
+
+// LoadState restores the machine from a snapshot file written by
+// SaveState.
+func (m *Machine) LoadState(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := m.Restore(f); err != nil {
+		return fmt.Errorf("couldn't load state from %q: %v", path, err)
+	}
+
+	return nil
+}
+
+// StackBytes serializes just the stack: a 32-bit little-endian count
+// followed by that many 16-bit little-endian values, bottom first.
+func (m *Machine) StackBytes() []byte {
+	data := m.stack.data
+	b := make([]byte, 4+2*len(data))
+
+	binary.LittleEndian.PutUint32(b, uint32(len(data)))
+	for i, v := range data {
+		binary.LittleEndian.PutUint16(b[4+2*i:], v)
+	}
+
+	return b
+}
+
+// LoadStack replaces the stack with one serialized by StackBytes,
+// leaving memory and registers alone.
+func (m *Machine) LoadStack(b []byte) error {
+	if len(b) < 4 {
+		return fmt.Errorf("stack data of %d bytes is too short", len(b))
+	}
+
+	n := binary.LittleEndian.Uint32(b)
+	if uint64(len(b)-4) != 2*uint64(n) {
+		return fmt.Errorf("stack data holds %d bytes of values, expected %d", len(b)-4, 2*uint64(n))
+	}
+
+	data := make([]uint16, n)
+	for i := range data {
+		data[i] = binary.LittleEndian.Uint16(b[4+2*i:])
+	}
+
+	m.stack.data = data
+	if m.stack.tags != nil {
+		m.stack.tags = make([]int, len(data))
+	}
+
+	// The loop detector only hashes the top of the stack, so count
+	// this as a write to keep the new stack distinct.
+	m.memWrites++
+
+	return nil
+}
+
+// Clone returns an independent copy of the machine: memory, registers,
+// stack, program counter, state, pending input and breakpoints are all
+// copied, so the two can be run separately. The input reader and
+// output writers are shared; use SetInputSource and SetOutput to give
+// the clone its own, bearing in mind that SetInputSource discards the
+// pending input. Auto-snapshots are off in the clone, so the two
+// don't overwrite each other's files; use SetAutoSnapshot with another
+// directory to turn them back on.
+func (m *Machine) Clone() *Machine {
+	c := *m
+
+	c.memory = append([]uint16{}, m.memory...)
+	c.regs = append([]uint16{}, m.regs...)
+	c.unused_input = append([]uint16{}, m.unused_input...)
+	c.captured = append([]byte{}, m.captured...)
+	c.calls = append([]uint16{}, m.calls...)
+	if m.commands != nil {
+		c.commands = append([]string{}, m.commands...)
+	}
+
+	c.stack = &Stack{data: append([]uint16{}, m.stack.data...)}
+	if m.stack.tags != nil {
+		c.stack.tags = append([]int{}, m.stack.tags...)
+	}
+
+	c.breakpoints = make(map[uint16]*breakpoint, len(m.breakpoints))
+	for addr, bp := range m.breakpoints {
+		b := *bp
+		c.breakpoints[addr] = &b
+	}
+
+	if m.timings != nil {
+		c.timings = append([]time.Duration{}, m.timings...)
+		c.opCounts = append([]uint64{}, m.opCounts...)
+	}
+
+	if m.profile != nil {
+		c.profile = append([]uint64{}, m.profile...)
+	}
+
+	c.autoSnap = nil
+
+	if m.loops != nil {
+		c.DetectLoops(m.loops.window, m.loops.handler)
+	}
+
+	return &c
+}
+
+// Where and how many snapshots to keep for SetAutoSnapshot.
+type autoSnapshot struct {
+	dir   string
+	keep  int
+	n     int
+	files []string
+}
+
+// SetAutoSnapshot saves a snapshot into dir each time the program
+// waits for a line of input, so any prompt can be returned to with
+// LoadState. Files are numbered in order, as snap-000001.syns and so
+// on. Only the most recent keep are kept, or all of them if keep is 0.
+// An empty dir turns auto-snapshots off.
+func (m *Machine) SetAutoSnapshot(dir string, keep int) error {
+	if dir == "" {
+		m.autoSnap = nil
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	m.autoSnap = &autoSnapshot{dir: dir, keep: keep}
+	return nil
+}
+
+// Save the next auto-snapshot and prune old ones. Failures are
+// reported to diag but don't stop the machine.
+func (m *Machine) saveAutoSnapshot() {
+	a := m.autoSnap
+	a.n++
+	path := filepath.Join(a.dir, fmt.Sprintf("snap-%06d.syns", a.n))
+
+	if err := m.SaveState(path); err != nil {
+		fmt.Fprintf(m.diag, "Auto-snapshot failed: %v\n", err)
+		return
+	}
+
+	a.files = append(a.files, path)
+	for a.keep > 0 && len(a.files) > a.keep {
+		if err := os.Remove(a.files[0]); err != nil {
+			fmt.Fprintf(m.diag, "Couldn't remove old auto-snapshot: %v\n", err)
+		}
+		a.files = a.files[1:]
+	}
+}
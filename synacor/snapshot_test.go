@@ -0,0 +1,256 @@
+package synacor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoadState(t *testing.T) {
+	const src = `
+		SET r0 5
+		PUSH r0
+		WMEM 100 r0
+		ADD r1 r0 r0
+		OUT 'x'
+		HALT`
+
+	m, _, _ := testMachine(t, src, "")
+	m.StepN(4)
+
+	path := filepath.Join(t.TempDir(), "state")
+	if err := m.SaveState(path); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	n, out, _ := testMachine(t, "HALT", "")
+	if err := n.LoadState(path); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	if n.PC() != m.PC() || !reflect.DeepEqual(n.regs, m.regs) || !reflect.DeepEqual(n.memory, m.memory) {
+		t.Errorf("restored pc 0x%04x, regs %v; want 0x%04x, %v", n.PC(), n.regs, m.PC(), m.regs)
+	}
+	if !reflect.DeepEqual(n.Stack().Slice(), m.Stack().Slice()) {
+		t.Errorf("restored stack %v, want %v", n.Stack().Slice(), m.Stack().Slice())
+	}
+
+	n.Run()
+	if n.State() != HALTED || out.String() != "x" {
+		t.Errorf("restored machine printed %q and ended %s, want \"x\" and HALTED", out, statesToString[n.State()])
+	}
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	m, _, _ := testMachine(t, "HALT", "")
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "state")
+	if err := m.SaveState(path); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"version", append([]byte("SYNS\x63\x00"), b[6:]...), "version 99"},
+		{"magic", append([]byte("NOPE"), b[4:]...), "not a snapshot"},
+		{"truncated", b[:len(b)/2], "couldn't read snapshot memory"},
+	} {
+		bad := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(bad, tc.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := m.LoadState(bad)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: LoadState error = %v, want one mentioning %q", tc.name, err, tc.want)
+		}
+	}
+
+	if err := m.LoadState(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("LoadState of a missing file = %v, want a not-exist error", err)
+	}
+}
+
+func TestStackBytes(t *testing.T) {
+	m, _, _ := testMachine(t, `
+		PUSH 1
+		PUSH 2
+		PUSH 32767
+		HALT`, "")
+	m.StepN(3)
+	b := m.StackBytes()
+
+	n, out, _ := testMachine(t, `
+		POP r0
+		OUT r0
+		POP r0
+		OUT r0
+		POP r0
+		OUT r0
+		HALT`, "")
+	n.regs[0] = 9
+	if err := n.LoadStack(b); err != nil {
+		t.Fatalf("LoadStack: %v", err)
+	}
+	if n.regs[0] != 9 {
+		t.Errorf("LoadStack changed r0 to %d", n.regs[0])
+	}
+	if !reflect.DeepEqual(n.stack.data, []uint16{1, 2, 32767}) {
+		t.Errorf("stack = %v, want [1 2 32767]", n.stack.data)
+	}
+	n.StepN(6)
+	if got, want := out.String(), "\u7fff\x02\x01"; got != want {
+		t.Errorf("popped %q, want %q", got, want)
+	}
+
+	for _, tc := range []struct {
+		b    []byte
+		want string
+	}{
+		{b[:3], "too short"},
+		{b[:len(b)-1], "expected 6"},
+		{append(b, 0, 0), "expected 6"},
+	} {
+		err := m.LoadStack(tc.b)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("LoadStack(% x) = %v, want error containing %q", tc.b, err, tc.want)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	m, out, _ := testMachine(t, `
+		PUSH 1
+		IN r0
+		WMEM 100 r0
+		POP r1
+		OUT r0
+		HALT`, "ab")
+	m.SetBreakpoint(9)
+	m.StepN(2)
+
+	c := m.Clone()
+	var cout strings.Builder
+	c.SetOutput(&cout)
+	c.regs[0] = 'z'
+	c.ClearBreakpoint(9)
+	c.Run()
+
+	if !c.Halted() || cout.String() != "z" || c.memory[100] != 'z' || c.regs[1] != 1 {
+		t.Errorf("clone halted %v, output %q, mem[100] %d, r1 %d; want halted, \"z\", 122, 1", c.Halted(), cout.String(), c.memory[100], c.regs[1])
+	}
+	if !reflect.DeepEqual(c.PendingInput(), []uint16{'b'}) {
+		t.Errorf("clone's pending input = %v, want [98]", c.PendingInput())
+	}
+
+	// None of that touched the original, which still has its stack,
+	// input and breakpoint.
+	if m.PC() != 4 || m.regs[0] != 'a' || m.memory[100] != 0 || m.StackDepth() != 1 || out.Len() != 0 {
+		t.Fatalf("original changed: %s", m)
+	}
+	m.Run()
+	if !m.AtBreakpoint() || m.PC() != 9 {
+		t.Errorf("original stopped at 0x%04x, want breakpoint at 0x0009", m.PC())
+	}
+	m.Run()
+	if out.String() != "a" || m.memory[100] != 'a' {
+		t.Errorf("original output %q and mem[100] %d, want \"a\" and 97", out.String(), m.memory[100])
+	}
+}
+
+func TestAutoSnapshot(t *testing.T) {
+	const src = `
+		IN r0
+		IN r1
+		IN r2
+		IN r3
+		OUT r0
+		OUT r2
+		HALT`
+
+	dir := t.TempDir()
+	m, out, _ := testMachine(t, src, "a\nb\n")
+	if err := m.SetAutoSnapshot(dir, 0); err != nil {
+		t.Fatalf("SetAutoSnapshot: %v", err)
+	}
+	if c := m.Clone(); c.autoSnap != nil {
+		t.Error("clone has auto-snapshots on")
+	}
+	m.Run()
+
+	if out.String() != "ab" {
+		t.Fatalf("output = %q, want \"ab\"", out.String())
+	}
+
+	// One snapshot at each prompt, not at each IN.
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	want := []string{filepath.Join(dir, "snap-000001.syns"), filepath.Join(dir, "snap-000002.syns")}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("snapshots = %v, want %v", files, want)
+	}
+
+	// Each restores to its prompt, ready for different input.
+	for i, want := range []string{"cd", "ac"} {
+		n, out, _ := testMachine(t, "HALT", "c\nd\n")
+		if err := n.LoadState(files[i]); err != nil {
+			t.Fatalf("LoadState(%s): %v", files[i], err)
+		}
+		n.Run()
+		if out.String() != want {
+			t.Errorf("from %s, output = %q, want %q", files[i], out.String(), want)
+		}
+	}
+
+	// Keeping one leaves only the latest.
+	dir = t.TempDir()
+	m, _, _ = testMachine(t, src, "a\nb\n")
+	m.SetAutoSnapshot(dir, 1)
+	m.Run()
+
+	files, _ = filepath.Glob(filepath.Join(dir, "*"))
+	if want := []string{filepath.Join(dir, "snap-000002.syns")}; !reflect.DeepEqual(files, want) {
+		t.Errorf("keeping 1, snapshots = %v, want %v", files, want)
+	}
+}
+
+func TestSnapshotPendingInput(t *testing.T) {
+	const src = `
+		IN r0
+		IN r1
+		IN r2
+		OUT r1
+		OUT r2
+		HALT`
+
+	m, _, _ := testMachine(t, src, "abc\n")
+	m.StepN(1)
+
+	path := filepath.Join(t.TempDir(), "state")
+	if err := m.SaveState(path); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	// The rest of the line comes back with the snapshot, so nothing
+	// needs to be read.
+	n, out, _ := testMachine(t, "HALT", "")
+	if err := n.LoadState(path); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if !reflect.DeepEqual(n.PendingInput(), []uint16{'b', 'c', '\n'}) {
+		t.Errorf("pending input = %v, want \"bc\\n\"", n.PendingInput())
+	}
+	n.Run()
+	if n.State() != HALTED || out.String() != "bc" {
+		t.Errorf("state %d with output %q, want HALTED with \"bc\"", n.State(), out.String())
+	}
+}
@@ -2,8 +2,14 @@ package synacor
 
 import (
 	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"time"
 )
 
 const (
@@ -11,6 +17,8 @@ const (
 	MAX_15BIT      = 32767 // Values are 0..MAX_15BIT
 	OVERFLOW_15BIT = 32768
 	MAX_REG        = MAX_15BIT + 8 // indirect register references
+
+	OUTPUT_CAPTURE_LIMIT = 1 << 20 // Bytes of captured output retained
 )
 
 // CPU states
@@ -20,6 +28,19 @@ const (
 	ERROR          // Halted in error state.
 )
 
+// Reasons StepN stopped early.
+var (
+	ErrHalted     = errors.New("machine halted")
+	ErrFault      = errors.New("machine halted in error")
+	ErrBreakpoint = errors.New("stopped at breakpoint")
+)
+
+var statesToString map[int]string = map[int]string{
+	RUNNING: "RUNNING",
+	HALTED:  "HALTED",
+	ERROR:   "ERROR",
+}
+
 // Instruction names
 const (
 	HALT = iota // 0: stop execution and terminate the program
@@ -109,22 +130,91 @@ func decipherReg(arg uint16) uint16 {
 	return arg - MAX_15BIT - 1
 }
 
+// Reduce v to 15 bits. All arithmetic is modulo 32768, and since
+// 65536 is a multiple of that, uint16 overflow along the way is
+// harmless. Without this a result could land in the register
+// reference range, which isn't a storable value.
+func mask15(v uint16) uint16 {
+	return v & MAX_15BIT
+}
+
+// Return the register index named by arg, if arg names a register.
+func regIndex(arg uint16) (int, bool) {
+	if !isReg(arg) {
+		return 0, false
+	}
+
+	return int(decipherReg(arg)), true
+}
+
+// Stack entry tags, recorded when tagging is enabled.
+const (
+	STACK_UNTAGGED = iota // Pushed before tagging was enabled
+	STACK_DATA            // Pushed by PUSH
+	STACK_RETURN          // Return address pushed by CALL
+)
+
+var stackTagsToString map[int]string = map[int]string{
+	STACK_UNTAGGED: "",
+	STACK_DATA:     "data",
+	STACK_RETURN:   "return",
+}
+
+type StackEntry struct {
+	Value uint16
+	Tag   int
+}
+
 type Stack struct {
 	data []uint16
+	tags []int // Parallel to data; nil unless tagging is enabled
 }
 
 func NewStack() *Stack {
 	return &Stack{data: make([]uint16, 0)}
 }
 
+// EnableTags starts recording a tag for each value pushed. Values
+// already on the stack are STACK_UNTAGGED.
+func (s *Stack) EnableTags() {
+	if s.tags == nil {
+		s.tags = make([]int, len(s.data), cap(s.data))
+	}
+}
+
 func (s *Stack) Push(v uint16) {
+	s.PushTagged(v, STACK_UNTAGGED)
+}
+
+// PushTagged pushes v, recording tag if tagging is enabled.
+func (s *Stack) PushTagged(v uint16, tag int) {
 	s.data = append(s.data, v)
+	if s.tags != nil {
+		s.tags = append(s.tags, tag)
+	}
 }
 
 func (s *Stack) IsEmpty() bool {
 	return len(s.data) == 0
 }
 
+func (s *Stack) Len() int {
+	return len(s.data)
+}
+
+// Slice returns a copy of the stack contents, bottom first.
+func (s *Stack) Slice() []StackEntry {
+	entries := make([]StackEntry, len(s.data))
+	for i, v := range s.data {
+		entries[i].Value = v
+		if s.tags != nil {
+			entries[i].Tag = s.tags[i]
+		}
+	}
+
+	return entries
+}
+
 func (s *Stack) Pop() (uint16, bool) {
 	if s.IsEmpty() {
 		return 0, false
@@ -133,6 +223,9 @@ func (s *Stack) Pop() (uint16, bool) {
 	idx := len(s.data) - 1
 	v := s.data[idx]
 	s.data = s.data[:idx]
+	if s.tags != nil {
+		s.tags = s.tags[:idx]
+	}
 
 	return v, true
 }
@@ -143,32 +236,436 @@ type Machine struct {
 	pc           uint16 // program counter
 	stack        *Stack
 	state        int
+	haltReason   string // Why the machine stopped, if it has
 	input        *bufio.Reader
+	inputTTY     bool     // Whether input comes from a terminal
+	prompt       int      // When to prompt for input
 	unused_input []uint16 // Available input
+	inputRead    uint64   // Characters consumed by IN
+	out          io.Writer
+	diag         io.Writer // Machine errors and diagnostics
+	tracer       io.Writer // Each instruction executed, if set
+	traceMode    int
+	transcript   io.Writer // Also receives program output, if set
+	echoInput    bool      // Write consumed input to out
+	newlines     int       // How OUT writes line endings
+	lastOut      uint16    // Last character written, for NEWLINE_CRLF
+	endOfMemory  int       // What running off the end of memory does
+	capture      bool      // Retain program output in captured
+	captured     []byte
+	breakpoints  map[uint16]*breakpoint
+	atBreak      bool // Stopped at a breakpoint
+	steps        int  // Instructions executed
+	stepLimit    int  // Error after this many steps; 0 is unlimited
+	loops        *loopDetector
+	memWrites    uint64          // Count of memory writes, for loop detection
+	timings      []time.Duration // Time spent in each opcode, if enabled
+	opCounts     []uint64        // Executions of each opcode, with timings
+	profile      []uint64        // Executions of each address, if enabled
+	inputEOF     bool            // Stopped because input ran out
+	original     []uint16        // Memory as loaded, if retained
+	calls        []uint16        // Shadow stack of CALL return addresses
+	onHalt       func(state int, reason string)
+	progLen      int      // Words in the loaded program
+	sanitize     int      // How non-ASCII input is treated
+	logInput     bool     // Report each IN that blocks for input to diag
+	commands     []string // Lines of input to use before reading any
+	commandsThen int      // What to do once commands run out
+	autoSnap     *autoSnapshot
 }
 
 func NewMachine(prog []uint16) *Machine {
+	return NewMachineWithOptions(prog)
+}
+
+// NewMachineWithOptions is NewMachine, with opts applied once the
+// machine is otherwise set up.
+func NewMachineWithOptions(prog []uint16, opts ...Option) *Machine {
 	m := &Machine{
 		memory:       make([]uint16, 32768), // 15-bits
 		regs:         make([]uint16, NREGS, NREGS),
 		stack:        NewStack(),
 		input:        bufio.NewReader(os.Stdin),
+		inputTTY:     isTerminal(os.Stdin),
 		unused_input: make([]uint16, 0),
+		out:          os.Stdout,
+		diag:         os.Stdout,
+		breakpoints:  make(map[uint16]*breakpoint),
 	}
 
-	copy(m.memory, prog)
+	m.progLen = copy(m.memory, prog)
+
+	for _, opt := range opts {
+		opt(m)
+	}
 
 	return m
 }
 
+// ProgramLen returns the number of words in the loaded program.
+func (m *Machine) ProgramLen() int {
+	return m.progLen
+}
+
+// NewMachineFromReader loads a program of 16-bit words from r. The
+// challenge binary uses binary.LittleEndian.
+func NewMachineFromReader(r io.Reader, order binary.ByteOrder, opts ...Option) (*Machine, error) {
+	bin, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bin)%2 != 0 {
+		return nil, fmt.Errorf("program has odd length %d", len(bin))
+	}
+
+	prog := make([]uint16, 0, len(bin)/2)
+	for i := 0; i < len(bin); i += 2 {
+		prog = append(prog, order.Uint16(bin[i:i+2]))
+	}
+
+	if len(prog) > OVERFLOW_15BIT {
+		return nil, fmt.Errorf("program of %d words doesn't fit in memory", len(prog))
+	}
+
+	return NewMachineWithOptions(prog, opts...), nil
+}
+
+// WriteBinary writes the whole memory image to w as 16-bit
+// little-endian words, the format NewMachineFromReader loads.
+func (m *Machine) WriteBinary(w io.Writer) error {
+	return m.DumpRange(0, uint16(len(m.memory)), w)
+}
+
+// DumpRange writes the memory words from start up to, but not
+// including, end to w as 16-bit little-endian words. An end beyond the
+// address space is clamped to it.
+func (m *Machine) DumpRange(start, end uint16, w io.Writer) error {
+	if int(end) > len(m.memory) {
+		end = uint16(len(m.memory))
+	}
+
+	if start > end {
+		return fmt.Errorf("invalid range 0x%04x:0x%04x", start, end)
+	}
+
+	return binary.Write(w, binary.LittleEndian, m.memory[start:end])
+}
+
+// SetStepLimit puts the machine in an error state once it has executed
+// n instructions. Zero removes the limit.
+func (m *Machine) SetStepLimit(n int) {
+	m.stepLimit = n
+}
+
+// Steps returns the number of instructions executed.
+func (m *Machine) Steps() int {
+	return m.steps
+}
+
+// EnableTimings accumulates the wall-clock time spent executing each
+// opcode, reported by Timings, and how many times each executed.
+func (m *Machine) EnableTimings() {
+	if m.timings == nil {
+		m.timings = make([]time.Duration, len(opsToString))
+		m.opCounts = make([]uint64, len(opsToString))
+	}
+}
+
+// Timings returns the time spent in each opcode executed so far, keyed
+// by opcode name.
+func (m *Machine) Timings() map[string]time.Duration {
+	t := make(map[string]time.Duration)
+	for op, d := range m.timings {
+		if m.opCounts[op] > 0 {
+			t[opsToString[op]] = d
+		}
+	}
+
+	return t
+}
+
+// Add the time since start to op's total. The slices grow as needed
+// for custom opcodes numbered beyond the built-in ones.
+func (m *Machine) recordTiming(op uint16, start time.Time) {
+	if _, ok := opsToString[int(op)]; !ok {
+		return
+	}
+
+	if n := int(op) + 1; n > len(m.timings) {
+		m.timings = append(m.timings, make([]time.Duration, n-len(m.timings))...)
+		m.opCounts = append(m.opCounts, make([]uint64, n-len(m.opCounts))...)
+	}
+
+	m.timings[op] += time.Since(start)
+	m.opCounts[op]++
+}
+
+// SetOutput directs everything the program prints to w.
+func (m *Machine) SetOutput(w io.Writer) {
+	m.out = w
+}
+
+// Treatment of line endings in program output.
+const (
+	NEWLINE_PASS     = iota // Default. Write output as is.
+	NEWLINE_CRLF            // Write each "\n" not already after "\r" as "\r\n".
+	NEWLINE_STRIP_CR        // Drop each "\r".
+)
+
+// SetNewlineMode chooses how line endings printed by OUT are written:
+// NEWLINE_PASS, NEWLINE_CRLF or NEWLINE_STRIP_CR. It applies to the
+// transcript and captured output too.
+func (m *Machine) SetNewlineMode(mode int) {
+	m.newlines = mode
+}
+
+// EnableOutputCapture retains everything the program prints, in
+// addition to writing it to the output. Only the most recent
+// OUTPUT_CAPTURE_LIMIT bytes are kept.
+func (m *Machine) EnableOutputCapture() {
+	m.capture = true
+}
+
+// Output returns the captured program output.
+func (m *Machine) Output() string {
+	if over := len(m.captured) - OUTPUT_CAPTURE_LIMIT; over > 0 {
+		return string(m.captured[over:])
+	}
+
+	return string(m.captured)
+}
+
+// SetDiag directs machine errors and other diagnostics to w.
+func (m *Machine) SetDiag(w io.Writer) {
+	m.diag = w
+}
+
+// SetTracer writes each instruction to w as it's executed. A nil w
+// turns tracing off.
+func (m *Machine) SetTracer(w io.Writer) {
+	m.tracer = w
+}
+
+// SetTranscript copies program output to w as well as the output
+// writer, typically a Transcript writing to a file.
+func (m *Machine) SetTranscript(w io.Writer) {
+	m.transcript = w
+}
+
+// SetEchoInput controls whether characters consumed by IN are written
+// to the output, and any transcript or capture, as they're read. This
+// is useful when input is piped in and a transcript should read as
+// though it were typed interactively.
+func (m *Machine) SetEchoInput(echo bool) {
+	m.echoInput = echo
+}
+
+// PC returns the program counter.
+func (m *Machine) PC() uint16 {
+	return m.pc
+}
+
+// SetPC moves execution to addr, which must be in memory. Together with
+// SetRegister it lets a subroutine be run on its own.
+func (m *Machine) SetPC(addr uint16) error {
+	if int(addr) >= len(m.memory) {
+		return fmt.Errorf("address %d is outside memory", addr)
+	}
+
+	m.pc = addr
+	m.atBreak = false
+	return nil
+}
+
+// Register returns the value of register n.
+func (m *Machine) Register(n int) uint16 {
+	return m.regs[n]
+}
+
+// SetRegister sets register n to v. Registers hold values, so v must
+// be at most MAX_15BIT; anything larger is rejected rather than masked
+// so scripting mistakes surface immediately.
+func (m *Machine) SetRegister(n int, v uint16) error {
+	if n < 0 || n >= NREGS {
+		return fmt.Errorf("no register %d", n)
+	}
+
+	if !isValue(v) {
+		return fmt.Errorf("value %d is out of range 0..%d", v, MAX_15BIT)
+	}
+
+	m.regs[n] = v
+	return nil
+}
+
+// WriteMemory sets the word at addr to v. Unlike registers, memory
+// also holds operands, so v may be a register reference (up to
+// MAX_REG); larger values are invalid anywhere and are rejected.
+func (m *Machine) WriteMemory(addr, v uint16) error {
+	if !isValue(addr) {
+		return fmt.Errorf("address %d is out of range 0..%d", addr, MAX_15BIT)
+	}
+
+	if v > MAX_REG {
+		return fmt.Errorf("value %d is out of range 0..%d", v, MAX_REG)
+	}
+
+	m.memory[addr] = v
+	m.memWrites++
+	return nil
+}
+
+// ReadMemory returns the word at addr.
+func (m *Machine) ReadMemory(addr uint16) uint16 {
+	return m.memory[addr]
+}
+
+// Stack returns the machine's stack.
+func (m *Machine) Stack() *Stack {
+	return m.stack
+}
+
+// EnableStackTags marks each value pushed as data or a return address,
+// reported by Stack().Slice().
+func (m *Machine) EnableStackTags() {
+	m.stack.EnableTags()
+}
+
+// StackDepth returns the number of values on the stack.
+func (m *Machine) StackDepth() int {
+	return m.stack.Len()
+}
+
+// String describes the machine's state: program counter, registers,
+// stack and the next instruction.
+func (m *Machine) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "pc: 0x%04x (%s) after %d steps\n", m.pc, statesToString[m.state], m.steps)
+
+	b.WriteString("regs:")
+	for i, r := range m.regs {
+		fmt.Fprintf(&b, " r%d=0x%04x", i, r)
+	}
+
+	fmt.Fprintf(&b, "\nstack (%d):", m.stack.Len())
+	for _, v := range m.stack.data {
+		fmt.Fprintf(&b, " 0x%04x", v)
+	}
+
+	if inst, ok := m.Decode(m.pc); ok {
+		fmt.Fprintf(&b, "\nnext: %s", inst)
+	} else {
+		b.WriteString("\nnext: <invalid>")
+	}
+
+	return b.String()
+}
+
 func (m *Machine) Halted() bool {
 	return m.state != RUNNING
 }
 
+// State returns RUNNING, HALTED or ERROR.
+func (m *Machine) State() int {
+	return m.state
+}
+
+// HaltReason returns the machine's state and why it stopped: the
+// message given to Error, or where HALT executed. The reason is empty
+// while the machine is running.
+func (m *Machine) HaltReason() (int, string) {
+	return m.state, m.haltReason
+}
+
+// Run executes instructions until the machine halts or reaches a
+// breakpoint. Calling Run again resumes from the breakpoint.
 func (m *Machine) Run() {
 	for !m.Halted() {
+		if m.checkBreakpoint() {
+			return
+		}
+		m.Step()
+	}
+}
+
+// RunContext is Run, but also stops when ctx is done, returning an
+// error that wraps ctx.Err() and names the address it stopped at.
+// Otherwise it returns the reason the machine stopped, as StepN does.
+// A program waiting for input can't be interrupted until the read
+// returns.
+func (m *Machine) RunContext(ctx context.Context) error {
+	for !m.Halted() {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("stopped at 0x%04x: %w", m.pc, ctx.Err())
+		default:
+		}
+
+		if m.checkBreakpoint() {
+			return ErrBreakpoint
+		}
+		m.Step()
+	}
+
+	return m.haltErr()
+}
+
+// StepN executes up to n instructions. It returns nil if all n were
+// executed, or the reason it stopped early.
+func (m *Machine) StepN(n int) error {
+	for i := 0; i < n; i++ {
+		if err := m.haltErr(); err != nil {
+			return err
+		}
+		if m.checkBreakpoint() {
+			return ErrBreakpoint
+		}
 		m.Step()
 	}
+
+	return m.haltErr()
+}
+
+// StepOver executes one instruction like StepN(1), except that a CALL
+// runs until the subroutine returns to the instruction after it. The
+// return is matched by call depth, so recursive calls back to the same
+// site don't stop it early. Breakpoints inside the subroutine still
+// stop execution.
+func (m *Machine) StepOver() error {
+	inst, ok := m.Decode(m.pc)
+	if !ok || inst.Op != CALL {
+		return m.StepN(1)
+	}
+
+	ret, depth := inst.Next(), len(m.calls)
+	for {
+		if err := m.StepN(1); err != nil {
+			return err
+		}
+
+		if m.pc == ret && len(m.calls) <= depth {
+			return nil
+		}
+	}
+}
+
+// CallDepth returns the number of CALLs that have yet to RET.
+func (m *Machine) CallDepth() int {
+	return len(m.calls)
+}
+
+// Return the StepN error for the machine's state, if it has halted.
+func (m *Machine) haltErr() error {
+	switch m.state {
+	case HALTED:
+		return ErrHalted
+	case ERROR:
+		return ErrFault
+	}
+
+	return nil
 }
 
 func (m *Machine) readArg(arg uint16) uint16 {
@@ -184,6 +681,23 @@ func (m *Machine) readArg(arg uint16) uint16 {
 	return 0
 }
 
+// Resolve arg to a memory address for RMEM or WMEM. A register can
+// hold a value read from memory that's past the end of it, such as a
+// register reference, which is an error.
+func (m *Machine) memAddr(arg uint16) (uint16, bool) {
+	addr := m.readArg(arg)
+	if m.Halted() {
+		return 0, false
+	}
+
+	if int(addr) >= len(m.memory) {
+		m.Error(fmt.Sprintf("%s at 0x%04x refers to address %d, outside memory.", opsToString[int(m.memory[m.pc])], m.pc, addr))
+		return 0, false
+	}
+
+	return addr, true
+}
+
 func (m *Machine) getArgs(op uint16) []uint16 {
 	if n := argsForOp[int(op)]; n > 0 {
 		return m.memory[m.pc+1 : m.pc+1+n]
@@ -192,34 +706,174 @@ func (m *Machine) getArgs(op uint16) []uint16 {
 	return []uint16{}
 }
 
+// Write v to the register or memory address named by dst.
+func (m *Machine) store(dst, v uint16) {
+	switch {
+	case isReg(dst):
+		m.regs[decipherReg(dst)] = v
+	case isValue(dst):
+		m.memory[dst] = v
+		m.memWrites++
+	default:
+		m.Error(fmt.Sprintf("Invalid destination '%d'.", dst))
+	}
+}
+
+// Write v to the register named by dst, which must be a register.
+func (m *Machine) setReg(dst, v uint16) {
+	r, ok := regIndex(dst)
+	if !ok {
+		m.Error(fmt.Sprintf("Expected a register, got '%d'.", dst))
+		return
+	}
+
+	m.regs[r] = v
+}
+
+// Transfer control to target, which must be a memory address. The
+// program counter is left on the branch if it isn't.
+func (m *Machine) jump(target uint16) {
+	if !isValue(target) {
+		m.Error(fmt.Sprintf("Jump from 0x%04x to 0x%04x is outside memory.", m.pc, target))
+		return
+	}
+
+	m.pc = target
+}
+
 // Move over the OP and the number of args for the OP
 func (m *Machine) nextProgramCounter(op uint16) uint16 {
 	return m.pc + 1 + argsForOp[int(op)]
 }
 
+// Write the character c to the output, capturing it if enabled.
+func (m *Machine) emit(c uint16) {
+	s := fmt.Sprintf("%c", c)
+	switch {
+	case m.newlines == NEWLINE_CRLF && c == '\n' && m.lastOut != '\r':
+		s = "\r\n"
+	case m.newlines == NEWLINE_STRIP_CR && c == '\r':
+		return
+	}
+	m.lastOut = c
+	io.WriteString(m.out, s)
+
+	if m.transcript != nil {
+		io.WriteString(m.transcript, s)
+	}
+
+	if m.capture {
+		m.captured = append(m.captured, s...)
+		// Trim in bulk so long runs don't copy the buffer per byte.
+		if len(m.captured) > 2*OUTPUT_CAPTURE_LIMIT {
+			over := len(m.captured) - OUTPUT_CAPTURE_LIMIT
+			m.captured = append(m.captured[:0], m.captured[over:]...)
+		}
+	}
+}
+
+// OnHalt arranges for fn to be called with the new state and a
+// description of why whenever the machine halts or errors.
+func (m *Machine) OnHalt(fn func(state int, reason string)) {
+	m.onHalt = fn
+}
+
 // Log error and halt machine.
 func (m *Machine) Error(msg string) {
-	fmt.Println(msg)
+	fmt.Fprintln(m.diag, msg)
 	m.state = ERROR
+	m.haltReason = msg
+
+	if m.onHalt != nil {
+		m.onHalt(ERROR, msg)
+	}
 }
 
 // Halt machine.
 func (m *Machine) Halt() {
+	m.halt(fmt.Sprintf("Halted at 0x%04x.", m.pc))
+}
+
+func (m *Machine) halt(reason string) {
 	m.state = HALTED
+	m.haltReason = reason
+
+	if m.onHalt != nil {
+		m.onHalt(HALTED, m.haltReason)
+	}
+}
+
+// What Step does when execution runs off the end of memory.
+const (
+	END_OF_MEMORY_HALT  = iota // Default. Halt, as if memory ended with HALT.
+	END_OF_MEMORY_ERROR        // Stop with an error.
+)
+
+// SetEndOfMemory chooses whether running off the end of memory halts
+// the machine (END_OF_MEMORY_HALT) or is an error (END_OF_MEMORY_ERROR).
+// An instruction whose operands would run past the end is always an
+// error.
+func (m *Machine) SetEndOfMemory(mode int) {
+	m.endOfMemory = mode
+}
+
+// Stop the machine if the instruction at the program counter isn't
+// wholly in memory, returning whether it did.
+func (m *Machine) checkEnd() bool {
+	if int(m.pc) >= len(m.memory) {
+		msg := fmt.Sprintf("Ran off the end of memory at 0x%04x.", m.pc)
+		if m.endOfMemory == END_OF_MEMORY_ERROR {
+			m.Error(msg)
+		} else {
+			m.halt(msg)
+		}
+		return true
+	}
+
+	if int(m.pc)+1+int(argsForOp[int(m.memory[m.pc])]) > len(m.memory) {
+		m.Error(fmt.Sprintf("Instruction at 0x%04x runs past the end of memory.", m.pc))
+		return true
+	}
+
+	return false
 }
 
 func (m *Machine) Step() {
+	m.atBreak = false
+	if m.checkEnd() {
+		return
+	}
+	if m.stepLimit > 0 && m.steps >= m.stepLimit {
+		m.Error(fmt.Sprintf("Step limit of %d reached.", m.stepLimit))
+		return
+	}
+	if m.loops != nil && m.loops.check(m) && m.Halted() {
+		return
+	}
+	m.steps++
+	if m.profile != nil {
+		m.profile[m.pc]++
+	}
+
 	op := m.memory[m.pc]
 	args := m.getArgs(op)
 
+	if m.tracer != nil {
+		defer m.endTrace(m.beginTrace())
+	}
+
+	if m.timings != nil {
+		defer m.recordTiming(op, time.Now())
+	}
+
 	switch op {
 	case HALT:
 		m.Halt()
 		return
 	case SET:
-		m.regs[decipherReg(args[0])] = m.readArg(args[1])
+		m.setReg(args[0], m.readArg(args[1]))
 	case PUSH:
-		m.stack.Push(m.readArg(args[0]))
+		m.stack.PushTagged(m.readArg(args[0]), STACK_DATA)
 	case POP:
 		v, ok := m.stack.Pop()
 		if !ok {
@@ -227,135 +881,133 @@ func (m *Machine) Step() {
 			return
 		}
 
-		if isReg(args[0]) {
-			m.regs[decipherReg(args[0])] = v
-		} else {
-			m.memory[args[0]] = v
-		}
+		m.store(args[0], v)
 	case EQ:
 		b, c := m.readArg(args[1]), m.readArg(args[2])
 		var eq uint16
 		if b == c {
 			eq = 1
 		}
-		if isReg(args[0]) {
-			m.regs[decipherReg(args[0])] = eq
-		} else {
-			m.memory[args[0]] = eq
-		}
+		m.store(args[0], eq)
 	case GT:
 		b, c := m.readArg(args[1]), m.readArg(args[2])
 		var gt uint16
 		if b > c {
 			gt = 1
 		}
-		if isReg(args[0]) {
-			m.regs[decipherReg(args[0])] = gt
-		} else {
-			m.memory[args[0]] = gt
-		}
+		m.store(args[0], gt)
 	case JMP:
-		m.pc = m.readArg(args[0])
+		m.jump(m.readArg(args[0]))
 		return
 	case JT:
 		if m.readArg(args[0]) != 0 {
-			m.pc = m.readArg(args[1])
+			m.jump(m.readArg(args[1]))
 			return
 		}
 	case JF:
 		if m.readArg(args[0]) == 0 {
-			m.pc = m.readArg(args[1])
+			m.jump(m.readArg(args[1]))
 			return
 		}
 	case ADD:
 		b, c := m.readArg(args[1]), m.readArg(args[2])
-		a := (b + c) % OVERFLOW_15BIT
+		a := mask15(b + c)
 
-		if isReg(args[0]) {
-			m.regs[decipherReg(args[0])] = a
-		} else {
-			m.memory[args[0]] = a
-		}
+		m.store(args[0], a)
 	case MULT:
 		b, c := m.readArg(args[1]), m.readArg(args[2])
-		a := (b * c) % OVERFLOW_15BIT
+		a := mask15(b * c)
 
-		if isReg(args[0]) {
-			m.regs[decipherReg(args[0])] = a
-		} else {
-			m.memory[args[0]] = a
-		}
+		m.store(args[0], a)
 	case MOD:
 		b, c := m.readArg(args[1]), m.readArg(args[2])
-		a := b % c
-
-		if isReg(args[0]) {
-			m.regs[decipherReg(args[0])] = a
-		} else {
-			m.memory[args[0]] = a
+		if c == 0 {
+			m.Error(fmt.Sprintf("Division by zero at 0x%04x.", m.pc))
+			return
 		}
+		a := mask15(b % c)
+
+		m.store(args[0], a)
 	case AND:
 		b, c := m.readArg(args[1]), m.readArg(args[2])
-		a := b & c
+		a := mask15(b & c)
 
-		if isReg(args[0]) {
-			m.regs[decipherReg(args[0])] = a
-		} else {
-			m.memory[args[0]] = a
-		}
+		m.store(args[0], a)
 	case OR:
 		b, c := m.readArg(args[1]), m.readArg(args[2])
-		a := b | c
+		a := mask15(b | c)
 
-		if isReg(args[0]) {
-			m.regs[decipherReg(args[0])] = a
-		} else {
-			m.memory[args[0]] = a
-		}
+		m.store(args[0], a)
 	case NOT:
 		b := m.readArg(args[1])
-		a := (b ^ MAX_15BIT)
-		if isReg(args[0]) {
-			m.regs[decipherReg(args[0])] = a
-		} else {
-			m.memory[args[0]] = a
-		}
+		a := mask15(b ^ MAX_15BIT)
+		m.store(args[0], a)
 	case RMEM:
-		m.regs[decipherReg(args[0])] = m.memory[m.readArg(args[1])]
+		addr, ok := m.memAddr(args[1])
+		if !ok {
+			return
+		}
+
+		m.setReg(args[0], m.memory[addr])
 	case WMEM:
-		m.memory[m.readArg(args[0])] = m.readArg(args[1])
+		addr, ok := m.memAddr(args[0])
+		if !ok {
+			return
+		}
+
+		m.memory[addr] = m.readArg(args[1])
+		m.memWrites++
 	case CALL:
-		m.stack.Push(m.nextProgramCounter(op))
-		m.pc = m.readArg(args[0])
+		m.stack.PushTagged(m.nextProgramCounter(op), STACK_RETURN)
+		m.calls = append(m.calls, m.nextProgramCounter(op))
+		m.jump(m.readArg(args[0]))
 		return
 	case RET:
+		if n := len(m.calls); n > 0 {
+			m.calls = m.calls[:n-1]
+		}
 		if npc, ok := m.stack.Pop(); ok {
-			m.pc = npc
+			m.jump(npc)
 		} else {
 			m.Error("Popped an empty stack.")
 		}
 		return
 	case OUT:
-		fmt.Printf("%c", m.readArg(args[0]))
+		m.emit(m.readArg(args[0]))
 	case IN:
 		if len(m.unused_input) == 0 {
-			fmt.Printf("Input: ")
-			input, _ := m.input.ReadString('\n')
-			for _, c := range input {
-				m.unused_input = append(m.unused_input, uint16(c))
+			if m.needsInput() {
+				if m.logInput {
+					fmt.Fprintf(m.diag, "IN at 0x%04x waiting for input into %s.\n", m.pc, destString(args[0]))
+				}
+				if m.autoSnap != nil {
+					m.saveAutoSnapshot()
+				}
+			}
+			if !m.readInput() {
+				return
 			}
 		}
 
-		if isReg(args[0]) {
-			m.regs[decipherReg(args[0])] = m.unused_input[0]
-		} else {
-			m.memory[m.readArg(args[0])] = m.unused_input[0]
+		if m.echoInput {
+			m.emit(m.unused_input[0])
 		}
 
+		m.store(args[0], m.unused_input[0])
 		m.unused_input = m.unused_input[1:]
+		m.inputRead++
 	case NOOP:
 	default:
-		m.Error(fmt.Sprintf("UNIMPLEMENTED INSTRUCTION %q.\n\n", opsToString[int(op)]))
+		fn, ok := customOps[op]
+		if !ok {
+			m.Error(fmt.Sprintf("Unknown opcode %d at 0x%04x.", op, m.pc))
+			return
+		}
+
+		fn(m, args)
+		if m.Halted() {
+			return
+		}
 	}
 
 	m.pc = m.nextProgramCounter(op)
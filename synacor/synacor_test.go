@@ -0,0 +1,617 @@
+package synacor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Assemble src into a machine that reads input, collecting its output
+// and diagnostics. A step limit keeps a broken program from hanging the
+// test.
+func testMachine(t *testing.T, src, input string) (*Machine, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+
+	prog, err := Assemble(src)
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+
+	var out, diag bytes.Buffer
+	m := NewMachineWithOptions(prog,
+		WithInput(strings.NewReader(input)),
+		WithOutput(&out),
+		WithDiag(&diag),
+		WithStepLimit(100000))
+
+	return m, &out, &diag
+}
+
+func TestEchoInput(t *testing.T) {
+	const src = `
+		OUT '>'
+		IN r0
+		IN r1
+		IN r2
+		OUT '!'
+		HALT`
+
+	for _, tc := range []struct {
+		echo bool
+		want string
+	}{
+		{false, ">!"},
+		{true, ">ab\n!"},
+	} {
+		m, out, _ := testMachine(t, src, "ab\n")
+		m.SetEchoInput(tc.echo)
+		m.Run()
+
+		if got := out.String(); got != tc.want {
+			t.Errorf("echo %v: output = %q, want %q", tc.echo, got, tc.want)
+		}
+	}
+}
+
+func TestInDestinations(t *testing.T) {
+	m, _, diag := testMachine(t, `
+		IN r3
+		IN 100
+		HALT`, "ab\n")
+	m.Run()
+
+	if m.State() != HALTED {
+		t.Fatalf("state = %s, want HALTED: %s", statesToString[m.State()], diag)
+	}
+	if got := m.Register(3); got != 'a' {
+		t.Errorf("r3 = %d, want %d", got, 'a')
+	}
+	if got := m.ReadMemory(100); got != 'b' {
+		t.Errorf("mem[100] = %d, want %d", got, 'b')
+	}
+}
+
+func TestOutputCapture(t *testing.T) {
+	m, out, _ := testMachine(t, `
+		OUT 'h'
+		OUT 'i'
+		OUT 10
+		HALT`, "")
+	m.EnableOutputCapture()
+	m.Run()
+
+	if got, want := m.Output(), out.String(); got != want || got != "hi\n" {
+		t.Errorf("Output() = %q, output writer got %q, want both %q", got, want, "hi\n")
+	}
+}
+
+func TestOutputCaptureLimit(t *testing.T) {
+	m, _, _ := testMachine(t, "HALT", "")
+	m.EnableOutputCapture()
+
+	for i := 0; i < OUTPUT_CAPTURE_LIMIT; i++ {
+		m.emit('a')
+	}
+	m.emit('z')
+
+	got := m.Output()
+	if len(got) != OUTPUT_CAPTURE_LIMIT {
+		t.Fatalf("len(Output()) = %d, want %d", len(got), OUTPUT_CAPTURE_LIMIT)
+	}
+	if !strings.HasSuffix(got, "az") {
+		t.Errorf("Output() ends %q, want the most recent output", got[len(got)-2:])
+	}
+}
+
+func TestSetLiteralDestination(t *testing.T) {
+	m, _, diag := testMachine(t, `
+		SET 5 1
+		HALT`, "")
+	m.Run()
+
+	if m.State() != ERROR {
+		t.Fatalf("state = %s, want ERROR", statesToString[m.State()])
+	}
+	if want := "Expected a register, got '5'."; !strings.Contains(diag.String(), want) {
+		t.Errorf("diag = %q, want it to contain %q", diag, want)
+	}
+}
+
+func TestStepN(t *testing.T) {
+	m, _, _ := testMachine(t, countLoop, "")
+
+	if err := m.StepN(5); err != nil {
+		t.Fatalf("StepN(5) = %v, want nil", err)
+	}
+	if m.PC() != 7 || m.Register(0) != 2 || m.Steps() != 5 {
+		t.Errorf("after 5 steps pc = 0x%04x, r0 = %d, steps = %d; want 0x0007, 2, 5", m.PC(), m.Register(0), m.Steps())
+	}
+
+	m.SetBreakpoint(11)
+	if err := m.StepN(100); err != ErrBreakpoint {
+		t.Errorf("StepN into breakpoint = %v, want ErrBreakpoint", err)
+	}
+	if m.PC() != 11 {
+		t.Errorf("stopped at 0x%04x, want 0x000b", m.PC())
+	}
+	m.ClearBreakpoint(11)
+
+	if err := m.StepN(100); err != ErrHalted {
+		t.Errorf("StepN past HALT = %v, want ErrHalted", err)
+	}
+	if m.Steps() != 32 {
+		t.Errorf("halted after %d steps, want 32", m.Steps())
+	}
+	if err := m.StepN(1); err != ErrHalted {
+		t.Errorf("StepN on a halted machine = %v, want ErrHalted", err)
+	}
+
+	m, _, _ = testMachine(t, "SET 5 1", "")
+	if err := m.StepN(10); err != ErrFault {
+		t.Errorf("StepN into a fault = %v, want ErrFault", err)
+	}
+}
+
+func TestStackTags(t *testing.T) {
+	m, _, _ := testMachine(t, `
+		PUSH 7
+		CALL sub
+		HALT
+sub:	PUSH 8
+		POP r0
+		RET`, "")
+	m.EnableStackTags()
+	m.SetBreakpoint(7)
+	m.Run()
+
+	want := []StackEntry{{7, STACK_DATA}, {4, STACK_RETURN}, {8, STACK_DATA}}
+	if got := m.Stack().Slice(); !reflect.DeepEqual(got, want) {
+		t.Errorf("stack in sub = %v, want %v", got, want)
+	}
+
+	m.Run()
+	want = []StackEntry{{7, STACK_DATA}}
+	if got := m.Stack().Slice(); !reflect.DeepEqual(got, want) {
+		t.Errorf("stack after RET = %v, want %v", got, want)
+	}
+
+	// Without tags, entries are untagged.
+	m, _, _ = testMachine(t, "PUSH 7\nHALT", "")
+	m.Run()
+	want = []StackEntry{{7, STACK_UNTAGGED}}
+	if got := m.Stack().Slice(); !reflect.DeepEqual(got, want) {
+		t.Errorf("untagged stack = %v, want %v", got, want)
+	}
+}
+
+func TestTimings(t *testing.T) {
+	m, _, _ := testMachine(t, countLoop, "")
+	if got := m.Timings(); len(got) != 0 {
+		t.Errorf("Timings() before enabling = %v, want empty", got)
+	}
+
+	m.EnableTimings()
+	m.Run()
+
+	got := m.Timings()
+	for _, op := range []string{"SET", "ADD", "EQ", "JF", "HALT"} {
+		if _, ok := got[op]; !ok {
+			t.Errorf("Timings() has no entry for %s", op)
+		}
+	}
+	if len(got) != 5 {
+		t.Errorf("Timings() = %v, want entries for just the 5 opcodes run", got)
+	}
+}
+
+func TestStepOver(t *testing.T) {
+	m, out, _ := testMachine(t, `
+		CALL sub
+		OUT 'x'
+		HALT
+sub:	SET r0 1
+		ADD r0 r0 1
+		RET`, "")
+
+	m.StepOver()
+	if m.PC() != 2 || m.Register(0) != 2 || m.Steps() != 4 {
+		t.Errorf("after stepping over CALL pc = 0x%04x, r0 = %d, steps = %d; want 0x0002, 2, 4", m.PC(), m.Register(0), m.Steps())
+	}
+
+	m.StepOver()
+	if m.PC() != 4 || out.String() != "x" {
+		t.Errorf("after stepping over OUT pc = 0x%04x, output %q; want 0x0004, \"x\"", m.PC(), out)
+	}
+}
+
+func TestStepOverRecursion(t *testing.T) {
+	const src = `
+		CALL rec
+		HALT
+rec:	ADD r0 r0 1
+		GT r1 3 r0
+		JF r1 done
+		CALL rec
+done:	RET`
+
+	m, _, _ := testMachine(t, src, "")
+	m.StepOver()
+	if m.PC() != 2 || m.Register(0) != 3 || m.CallDepth() != 0 {
+		t.Errorf("after stepping over recursion pc = 0x%04x, r0 = %d, depth %d; want 0x0002, 3, 0", m.PC(), m.Register(0), m.CallDepth())
+	}
+
+	// A breakpoint inside the subroutine still stops it.
+	m, _, _ = testMachine(t, src, "")
+	m.SetBreakpoint(16)
+	if err := m.StepOver(); err != ErrBreakpoint || m.PC() != 16 {
+		t.Errorf("StepOver = %v at 0x%04x, want ErrBreakpoint at 0x0010", err, m.PC())
+	}
+}
+
+func TestUnknownOpcode(t *testing.T) {
+	m, _, diag := testMachine(t, "NOOP\n.word 42", "")
+	m.Run()
+
+	if m.State() != ERROR {
+		t.Fatalf("state = %s, want ERROR", statesToString[m.State()])
+	}
+	if want := "Unknown opcode 42 at 0x0001."; !strings.Contains(diag.String(), want) {
+		t.Errorf("diag = %q, want it to contain %q", diag, want)
+	}
+}
+
+func TestByteOrder(t *testing.T) {
+	prog := []uint16{SET, 0x8000, 0x1234, OUT, 'a', HALT, 0xff00}
+
+	var images [][]uint16
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var b bytes.Buffer
+		if err := binary.Write(&b, order, prog); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := NewMachineFromReader(&b, order)
+		if err != nil {
+			t.Fatalf("%v: NewMachineFromReader: %v", order, err)
+		}
+		if m.ProgramLen() != len(prog) {
+			t.Errorf("%v: loaded %d words, want %d", order, m.ProgramLen(), len(prog))
+		}
+		images = append(images, m.memory)
+	}
+
+	if !reflect.DeepEqual(images[0], images[1]) {
+		t.Errorf("little- and big-endian images differ")
+	}
+	if !reflect.DeepEqual(images[0][:len(prog)], prog) {
+		t.Errorf("loaded %v, want %v", images[0][:len(prog)], prog)
+	}
+
+	if _, err := NewMachineFromReader(bytes.NewReader([]byte{1, 2, 3}), binary.LittleEndian); err == nil {
+		t.Errorf("loading an odd number of bytes succeeded")
+	}
+}
+
+func TestDumpRange(t *testing.T) {
+	m := NewMachine([]uint16{0x0102, 0x0304, 0x0506, 0x0708})
+
+	var b bytes.Buffer
+	if err := m.DumpRange(1, 3, &b); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x04, 0x03, 0x06, 0x05}; !bytes.Equal(b.Bytes(), want) {
+		t.Errorf("DumpRange(1, 3) = % x, want % x", b.Bytes(), want)
+	}
+
+	b.Reset()
+	if err := m.DumpRange(0x7ffe, 0xffff, &b); err != nil || b.Len() != 4 {
+		t.Errorf("DumpRange past the end wrote %d bytes, %v; want 4 bytes clamped to memory", b.Len(), err)
+	}
+
+	b.Reset()
+	if err := m.WriteBinary(&b); err != nil || b.Len() != 2*len(m.memory) {
+		t.Errorf("WriteBinary wrote %d bytes, %v; want %d", b.Len(), err, 2*len(m.memory))
+	}
+
+	if err := m.DumpRange(3, 1, &b); err == nil {
+		t.Errorf("DumpRange(3, 1) succeeded, want an error")
+	}
+}
+
+func TestOnHalt(t *testing.T) {
+	for _, tc := range []struct {
+		src    string
+		state  int
+		reason string
+	}{
+		{"NOOP\nNOOP\nHALT", HALTED, "Halted at 0x0002."},
+		{"NOOP\nPOP r0", ERROR, "Popped an empty stack."},
+	} {
+		m, _, _ := testMachine(t, tc.src, "")
+
+		calls := 0
+		m.OnHalt(func(state int, reason string) {
+			calls++
+			if state != tc.state || reason != tc.reason {
+				t.Errorf("OnHalt(%s, %q), want (%s, %q)", statesToString[state], reason, statesToString[tc.state], tc.reason)
+			}
+		})
+		m.Run()
+
+		if calls != 1 {
+			t.Errorf("%q: OnHalt called %d times, want once", tc.src, calls)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	prog, err := Assemble(`
+		IN r0
+		OUT r0
+		HALT
+spin:	JMP spin`)
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+
+	var out, diag, trace bytes.Buffer
+	m := NewMachineWithOptions(prog,
+		WithInput(strings.NewReader("x")),
+		WithOutput(&out),
+		WithDiag(&diag),
+		WithTracer(&trace))
+	m.Run()
+
+	if got := out.String(); got != "x" {
+		t.Errorf("output = %q, want %q", got, "x")
+	}
+	if got := strings.Count(trace.String(), "\n"); got != 3 {
+		t.Errorf("traced %d lines, want 3:\n%s", got, trace.String())
+	}
+
+	diag.Reset()
+	m = NewMachineWithOptions(prog,
+		WithDiag(&diag),
+		WithEntry(5),
+		WithStepLimit(10))
+	m.Run()
+
+	if got, want := diag.String(), "Step limit of 10 reached.\n"; got != want {
+		t.Errorf("diag = %q, want %q", got, want)
+	}
+	if m.Steps() != 10 {
+		t.Errorf("ran %d steps, want 10", m.Steps())
+	}
+}
+
+func TestMask15(t *testing.T) {
+	m, _, _ := testMachine(t, `
+		ADD r0 32767 32767
+		MULT r1 32767 32767
+		MOD r2 32767 32767
+		AND r3 32767 32767
+		OR r4 32767 32767
+		NOT r5 0
+		NOT r6 32767
+		ADD r7 32767 1
+		HALT`, "")
+	m.Run()
+
+	want := []uint16{32766, 1, 0, 32767, 32767, 32767, 0, 0}
+	if !reflect.DeepEqual(m.regs, want) {
+		t.Errorf("regs = %v, want %v", m.regs, want)
+	}
+}
+
+func TestSetRegister(t *testing.T) {
+	m := NewMachine(nil)
+
+	for _, tc := range []struct {
+		n    int
+		v    uint16
+		want string
+	}{
+		{0, 0, "<nil>"},
+		{7, 32767, "<nil>"},
+		{3, 32768, "value 32768 is out of range 0..32767"},
+		{8, 1, "no register 8"},
+		{-1, 1, "no register -1"},
+	} {
+		err := m.SetRegister(tc.n, tc.v)
+		if got := fmt.Sprint(err); got != tc.want {
+			t.Errorf("SetRegister(%d, %d) = %v, want %q", tc.n, tc.v, err, tc.want)
+		}
+	}
+	if m.Register(7) != 32767 || m.Register(3) != 0 {
+		t.Errorf("r7 = %d, r3 = %d; want 32767, 0", m.Register(7), m.Register(3))
+	}
+
+	if err := m.WriteMemory(10, MAX_REG); err != nil || m.ReadMemory(10) != MAX_REG {
+		t.Errorf("WriteMemory(10, %d) = %v, memory holds %d", MAX_REG, err, m.ReadMemory(10))
+	}
+	if err := m.WriteMemory(10, MAX_REG+1); err == nil {
+		t.Errorf("WriteMemory(10, %d) succeeded", MAX_REG+1)
+	}
+	if err := m.WriteMemory(32768, 0); err == nil {
+		t.Error("WriteMemory(32768, 0) succeeded")
+	}
+}
+
+func TestJumpOutsideMemory(t *testing.T) {
+	// A register can pick up a register reference from memory, which
+	// is no place to jump to.
+	m, _, diag := testMachine(t, `
+		RMEM r0 bad
+		JMP r0
+		HALT
+bad:	.word 32770`, "")
+	m.Run()
+
+	want := "Jump from 0x0003 to 0x8002 is outside memory."
+	if m.State() != ERROR || m.PC() != 3 || diag.String() != want+"\n" {
+		t.Errorf("state %d at 0x%04x with diag %q, want ERROR at 0x0003 with %q", m.State(), m.PC(), diag.String(), want)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	m, _, _ := testMachine(t, "spin: JMP spin", "")
+	m.SetStepLimit(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := m.RunContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("RunContext = %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("RunContext took %v to notice the deadline", d)
+	}
+	if m.Halted() || m.Steps() == 0 {
+		t.Errorf("halted %v after %d steps, want still running after some", m.Halted(), m.Steps())
+	}
+
+	// Nothing stops a program that halts by itself.
+	m, _, _ = testMachine(t, countLoop, "")
+	if err := m.RunContext(context.Background()); err != ErrHalted {
+		t.Errorf("RunContext = %v, want ErrHalted", err)
+	}
+}
+
+func TestEntryPoint(t *testing.T) {
+	prog, err := Assemble(`
+		SET r0 100   ; prologue that would clobber r0
+		HALT
+double:	ADD r1 r0 r0
+		HALT`)
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+
+	var diag bytes.Buffer
+	m := NewMachineWithOptions(prog, WithDiag(&diag), WithEntry(4))
+	if err := m.SetRegister(0, 21); err != nil {
+		t.Fatal(err)
+	}
+	m.Run()
+
+	if m.State() != HALTED || m.Register(0) != 21 || m.Register(1) != 42 || m.Steps() != 2 {
+		t.Errorf("state %d after %d steps with r0 = %d, r1 = %d; want HALTED after 2 with 21, 42", m.State(), m.Steps(), m.Register(0), m.Register(1))
+	}
+
+	m = NewMachineWithOptions(prog, WithDiag(&diag), WithEntry(40000))
+	state, reason := m.HaltReason()
+	if want := "Invalid entry point: address 40000 is outside memory."; state != ERROR || reason != want {
+		t.Errorf("HaltReason() = %d, %q; want ERROR, %q", state, reason, want)
+	}
+}
+
+func TestMemoryAddressOutsideMemory(t *testing.T) {
+	for _, tc := range []struct {
+		op   string
+		want string
+	}{
+		{"WMEM r0 5", "WMEM at 0x0003 refers to address 32768, outside memory."},
+		{"RMEM r1 r0", "RMEM at 0x0003 refers to address 32768, outside memory."},
+	} {
+		// r0 picks up a register reference, one past the last address.
+		m, _, diag := testMachine(t, "RMEM r0 bad\n"+tc.op+"\nHALT\nbad: .word 32768", "")
+		m.Run()
+
+		if m.State() != ERROR || m.PC() != 3 || diag.String() != tc.want+"\n" {
+			t.Errorf("%s: state %d at 0x%04x with diag %q, want ERROR at 0x0003 with %q", tc.op, m.State(), m.PC(), diag.String(), tc.want)
+		}
+	}
+}
+
+func TestHaltReason(t *testing.T) {
+	m, _, _ := testMachine(t, countLoop, "")
+	if state, reason := m.HaltReason(); state != RUNNING || reason != "" {
+		t.Errorf("before running, HaltReason() = %d, %q; want RUNNING, \"\"", state, reason)
+	}
+	m.Run()
+	if state, reason := m.HaltReason(); state != HALTED || reason != "Halted at 0x000e." {
+		t.Errorf("after HALT, HaltReason() = %d, %q; want HALTED, \"Halted at 0x000e.\"", state, reason)
+	}
+
+	m, _, _ = testMachine(t, "NOOP\nMOD r0 1 0\nHALT", "")
+	m.Run()
+	state, reason := m.HaltReason()
+	if state != ERROR || m.State() != ERROR || reason != "Division by zero at 0x0001." {
+		t.Errorf("after a fault, HaltReason() = %d, %q; want ERROR, \"Division by zero at 0x0001.\"", state, reason)
+	}
+}
+
+func TestNewlineMode(t *testing.T) {
+	src := outString("a\r\nb\n") + "HALT"
+
+	for _, tc := range []struct {
+		mode int
+		want string
+	}{
+		{NEWLINE_PASS, "a\r\nb\n"},
+		{NEWLINE_CRLF, "a\r\nb\r\n"},
+		{NEWLINE_STRIP_CR, "a\nb\n"},
+	} {
+		m, out, _ := testMachine(t, src, "")
+		m.SetNewlineMode(tc.mode)
+		m.EnableOutputCapture()
+		m.Run()
+
+		if got := out.String(); got != tc.want {
+			t.Errorf("mode %d: output = %q, want %q", tc.mode, got, tc.want)
+		}
+		if got := m.Output(); got != tc.want {
+			t.Errorf("mode %d: captured output = %q, want %q", tc.mode, got, tc.want)
+		}
+	}
+
+	// Input that already ends in "\r\n" is left alone, and echoed
+	// input is output like any other.
+	for _, tc := range []struct {
+		input string
+		echo  bool
+		want  string
+	}{
+		{"hi\r\n", false, "hi\r\n"},
+		{"hi\n", true, "hhii\r\n\r\n"},
+	} {
+		m, out, _ := testMachine(t, echoLine, tc.input)
+		m.SetNewlineMode(NEWLINE_CRLF)
+		m.SetEchoInput(tc.echo)
+		m.Run()
+		if got := out.String(); got != tc.want {
+			t.Errorf("input %q, echo %v: output = %q, want %q", tc.input, tc.echo, got, tc.want)
+		}
+	}
+}
+
+func TestEndOfMemory(t *testing.T) {
+	for _, tc := range []struct {
+		mode  int
+		op    uint16
+		state int
+		want  string
+	}{
+		{END_OF_MEMORY_HALT, NOOP, HALTED, "Ran off the end of memory at 0x8000."},
+		{END_OF_MEMORY_ERROR, NOOP, ERROR, "Ran off the end of memory at 0x8000."},
+		{END_OF_MEMORY_HALT, OUT, ERROR, "Instruction at 0x7fff runs past the end of memory."},
+	} {
+		var diag bytes.Buffer
+		m := NewMachineWithOptions(nil, WithDiag(&diag), WithEntry(0x7fff))
+		m.SetEndOfMemory(tc.mode)
+		m.WriteMemory(0x7fff, tc.op)
+		m.Run()
+
+		if state, reason := m.HaltReason(); state != tc.state || reason != tc.want {
+			t.Errorf("mode %d with %s last: HaltReason() = %d, %q; want %d, %q", tc.mode, opsToString[int(tc.op)], state, reason, tc.state, tc.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package synacor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Trace modes
+const (
+	TRACE_PLAIN     = iota // Default. Each instruction's disassembly.
+	TRACE_ANNOTATED        // Also operand values, results and branch outcomes.
+)
+
+// Opcodes whose first operand is written rather than read.
+var destOps map[uint16]bool = map[uint16]bool{
+	SET:  true,
+	POP:  true,
+	EQ:   true,
+	GT:   true,
+	ADD:  true,
+	MULT: true,
+	MOD:  true,
+	AND:  true,
+	OR:   true,
+	NOT:  true,
+	RMEM: true,
+	IN:   true,
+}
+
+// SetTraceMode chooses how much detail the tracer writes.
+func (m *Machine) SetTraceMode(mode int) {
+	m.traceMode = mode
+}
+
+// The parts of an annotated trace line known before execution.
+type traceLine struct {
+	inst  Instruction
+	reads []string
+	taken bool // For JT and JF, whether the condition holds
+}
+
+// Write the trace line for the instruction at pc, or begin one to be
+// finished by endTrace after it executes.
+func (m *Machine) beginTrace() *traceLine {
+	inst, ok := m.Decode(m.pc)
+	if !ok {
+		return nil
+	}
+
+	if m.traceMode != TRACE_ANNOTATED {
+		fmt.Fprintln(m.tracer, inst)
+		return nil
+	}
+
+	t := &traceLine{inst: inst}
+	for i, arg := range inst.Args {
+		if i == 0 && destOps[inst.Op] {
+			continue
+		}
+		if isReg(arg) {
+			t.reads = append(t.reads, fmt.Sprintf("%s=0x%04x", operandString(arg), m.regs[decipherReg(arg)]))
+		}
+	}
+
+	if inst.Op == JT || inst.Op == JF {
+		cond := inst.Args[0]
+		if isReg(cond) {
+			cond = m.regs[decipherReg(cond)]
+		}
+		t.taken = (cond != 0) == (inst.Op == JT)
+	}
+
+	return t
+}
+
+func (m *Machine) endTrace(t *traceLine) {
+	if t == nil {
+		return
+	}
+
+	notes := t.reads
+	inst := t.inst
+
+	switch {
+	case m.state == ERROR:
+		notes = append(notes, "error")
+	case inst.Op == JT || inst.Op == JF:
+		if t.taken {
+			notes = append(notes, fmt.Sprintf("taken -> 0x%04x", m.pc))
+		} else {
+			notes = append(notes, "not taken")
+		}
+	case inst.Op == JMP || inst.Op == CALL || inst.Op == RET:
+		notes = append(notes, fmt.Sprintf("-> 0x%04x", m.pc))
+	case inst.Op == WMEM:
+		if addr := m.readArg(inst.Args[0]); isValue(addr) {
+			notes = append(notes, fmt.Sprintf("=> mem[0x%04x]=0x%04x", addr, m.memory[addr]))
+		}
+	case destOps[inst.Op]:
+		if dst := inst.Args[0]; isReg(dst) {
+			notes = append(notes, fmt.Sprintf("=> %s=0x%04x", destString(dst), m.regs[decipherReg(dst)]))
+		} else if isValue(dst) {
+			notes = append(notes, fmt.Sprintf("=> %s=0x%04x", destString(dst), m.memory[dst]))
+		}
+	}
+
+	if len(notes) == 0 {
+		fmt.Fprintln(m.tracer, inst)
+		return
+	}
+
+	fmt.Fprintf(m.tracer, "%-32s ; %s\n", inst, strings.Join(notes, " "))
+}
@@ -0,0 +1,39 @@
+package synacor
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAnnotatedTrace(t *testing.T) {
+	m, _, _ := testMachine(t, `
+		JT 1 next
+next:	SET r0 0
+		JF r0 skip
+		HALT
+skip:	JT r0 skip
+		CALL fn
+		HALT
+fn:		ADD r1 r0 7
+		RET`, "")
+
+	var trace bytes.Buffer
+	m.SetTracer(&trace)
+	m.SetTraceMode(TRACE_ANNOTATED)
+	m.Run()
+
+	// The first jump is taken even though its target is the next
+	// instruction anyway.
+	want := `0x0000: JT 0x0001 0x0003         ; taken -> 0x0003
+0x0003: SET r0 0x0000            ; => r0=0x0000
+0x0006: JF r0 0x000a             ; r0=0x0000 taken -> 0x000a
+0x000a: JT r0 0x000a             ; r0=0x0000 not taken
+0x000d: CALL 0x0010              ; -> 0x0010
+0x0010: ADD r1 r0 0x0007         ; r0=0x0000 => r1=0x0007
+0x0014: RET                      ; -> 0x000f
+0x000f: HALT
+`
+	if got := trace.String(); got != want {
+		t.Errorf("trace =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package synacor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A Transcript decorates a writer, prefixing each line written through
+// it with a line number and the time since the transcript began.
+type Transcript struct {
+	w     io.Writer
+	start time.Time
+	line  int
+	buf   []byte // Incomplete last line
+}
+
+func NewTranscript(w io.Writer) *Transcript {
+	return &Transcript{w: w, start: time.Now()}
+}
+
+// Write buffers p, writing out each line it completes.
+func (t *Transcript) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+
+	for {
+		i := bytes.IndexByte(t.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		if err := t.writeLine(t.buf[:i+1]); err != nil {
+			return len(p), err
+		}
+		t.buf = t.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush writes any incomplete last line.
+func (t *Transcript) Flush() error {
+	if len(t.buf) == 0 {
+		return nil
+	}
+
+	err := t.writeLine(append(t.buf, '\n'))
+	t.buf = t.buf[:0]
+	return err
+}
+
+func (t *Transcript) writeLine(line []byte) error {
+	t.line++
+	_, err := fmt.Fprintf(t.w, "%5d [%9.3fs] %s", t.line, time.Since(t.start).Seconds(), line)
+	return err
+}
@@ -0,0 +1,38 @@
+package synacor
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTranscript(t *testing.T) {
+	m, out, _ := testMachine(t, outString("one\ntwo\nthree")+"HALT", "")
+
+	var b bytes.Buffer
+	tr := NewTranscript(&b)
+	m.SetTranscript(tr)
+	m.Run()
+
+	if got, want := out.String(), "one\ntwo\nthree"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	// The unfinished last line waits for Flush.
+	if n := strings.Count(b.String(), "\n"); n != 2 {
+		t.Errorf("transcript has %d lines before Flush, want 2:\n%s", n, b.String())
+	}
+	if err := tr.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	lines := strings.SplitAfter(b.String(), "\n")
+	for i, text := range []string{"one", "two", "three"} {
+		re := regexp.MustCompile(fmt.Sprintf(`^ {4}%d \[ +\d+\.\d{3}s\] %s\n$`, i+1, text))
+		if i >= len(lines) || !re.MatchString(lines[i]) {
+			t.Errorf("transcript =\n%s\nwant line %d to match %s", b.String(), i+1, re)
+		}
+	}
+}
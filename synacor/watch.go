@@ -0,0 +1,229 @@
+package synacor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An Expr is a parsed watch expression. Expressions are built from:
+//
+//	r0..r7     register values
+//	pc         the program counter
+//	sp         the stack depth
+//	123, 0x7b  literal numbers
+//	mem[e]     the memory word at address e
+//	*e         shorthand for mem[e]
+//	e + e      addition
+//	e - e      subtraction
+//	(e)        grouping
+type Expr struct {
+	src  string
+	eval func(m *Machine) (int, error)
+}
+
+// String returns the source text of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval computes the value of the expression against the current state
+// of m.
+func (e *Expr) Eval(m *Machine) (int, error) {
+	return e.eval(m)
+}
+
+// ParseExpr parses a watch expression.
+func ParseExpr(src string) (*Expr, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{toks: toks}
+	eval, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q in %q", p.peek(), src)
+	}
+
+	return &Expr{src: src, eval: eval}, nil
+}
+
+func tokenize(src string) ([]string, error) {
+	toks := make([]string, 0)
+	rs := []rune(src)
+
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.ContainsRune("+-*[]()", r):
+			toks = append(toks, string(r))
+			i++
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			toks = append(toks, strings.ToLower(string(rs[i:j])))
+			i = j
+		default:
+			return nil, fmt.Errorf("invalid character %q in %q", r, src)
+		}
+	}
+
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	return toks, nil
+}
+
+type exprParser struct {
+	toks []string
+	pos  int
+}
+
+type evalFunc func(m *Machine) (int, error)
+
+func (p *exprParser) done() bool {
+	return p.pos >= len(p.toks)
+}
+
+func (p *exprParser) peek() string {
+	if p.done() {
+		return ""
+	}
+
+	return p.toks[p.pos]
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *exprParser) expect(t string) error {
+	if got := p.next(); got != t {
+		if got == "" {
+			return fmt.Errorf("expected %q at end of expression", t)
+		}
+		return fmt.Errorf("expected %q, got %q", t, got)
+	}
+
+	return nil
+}
+
+// expr := unary (('+' | '-') unary)*
+func (p *exprParser) expr() (evalFunc, error) {
+	lhs, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.next()
+		rhs, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		l := lhs
+		lhs = func(m *Machine) (int, error) {
+			a, err := l(m)
+			if err != nil {
+				return 0, err
+			}
+			b, err := rhs(m)
+			if err != nil {
+				return 0, err
+			}
+			if op == "+" {
+				return a + b, nil
+			}
+			return a - b, nil
+		}
+	}
+
+	return lhs, nil
+}
+
+// unary := '*' unary | primary
+func (p *exprParser) unary() (evalFunc, error) {
+	if p.peek() == "*" {
+		p.next()
+		addr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return memAt(addr), nil
+	}
+
+	return p.primary()
+}
+
+// primary := number | register | 'pc' | 'sp' | 'mem' '[' expr ']' | '(' expr ')'
+func (p *exprParser) primary() (evalFunc, error) {
+	t := p.next()
+
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case t == "(":
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case t == "mem":
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+		addr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return memAt(addr), nil
+	case t == "pc":
+		return func(m *Machine) (int, error) { return int(m.PC()), nil }, nil
+	case t == "sp":
+		return func(m *Machine) (int, error) { return m.StackDepth(), nil }, nil
+	case len(t) == 2 && t[0] == 'r' && '0' <= t[1] && t[1] < '0'+NREGS:
+		n := int(t[1] - '0')
+		return func(m *Machine) (int, error) { return int(m.Register(n)), nil }, nil
+	case unicode.IsDigit(rune(t[0])):
+		v, err := strconv.ParseUint(t, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t)
+		}
+		return func(m *Machine) (int, error) { return int(v), nil }, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q", t)
+}
+
+func memAt(addr evalFunc) evalFunc {
+	return func(m *Machine) (int, error) {
+		a, err := addr(m)
+		if err != nil {
+			return 0, err
+		}
+		if a < 0 || a > MAX_15BIT {
+			return 0, fmt.Errorf("address %d out of range", a)
+		}
+		return int(m.ReadMemory(uint16(a))), nil
+	}
+}
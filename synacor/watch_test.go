@@ -0,0 +1,76 @@
+package synacor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	m, _, _ := testMachine(t, "HALT", "")
+	m.SetRegister(0, 3)
+	m.SetRegister(1, 4)
+	m.SetRegister(7, 0x10)
+	m.WriteMemory(0x10, 99)
+	m.WriteMemory(0x1234, 7)
+	m.Stack().Push(1)
+	m.Stack().Push(2)
+	m.SetPC(0x20)
+
+	for _, tc := range []struct {
+		expr string
+		want int
+	}{
+		{"r0", 3},
+		{"r0+r1", 7},
+		{"r1 - r0", 1},
+		{"r0 - r1", -1},
+		{"0x10 + 10", 26},
+		{"mem[0x1234]", 7},
+		{"mem[r7]", 99},
+		{"*r7", 99},
+		{"*(r7)+1", 100},
+		{"r1 - (r0 + 1)", 0},
+		{"pc", 0x20},
+		{"sp", 2},
+		{"R1", 4},
+	} {
+		e, err := ParseExpr(tc.expr)
+		if err != nil {
+			t.Errorf("ParseExpr(%q): %v", tc.expr, err)
+			continue
+		}
+
+		got, err := e.Eval(m)
+		if err != nil || got != tc.want {
+			t.Errorf("%q = %d, %v; want %d", tc.expr, got, err, tc.want)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	for _, tc := range []struct {
+		expr, want string
+	}{
+		{"", "empty expression"},
+		{"r0 +", "unexpected end"},
+		{"mem[r0", `expected "]"`},
+		{"r0 $ 1", "invalid character"},
+		{"r0 r1", "unexpected"},
+		{"bogus", "unexpected"},
+	} {
+		_, err := ParseExpr(tc.expr)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("ParseExpr(%q) error = %v, want one mentioning %q", tc.expr, err, tc.want)
+		}
+	}
+
+	// Valid syntax can still fail against the machine.
+	m, _, _ := testMachine(t, "HALT", "")
+	e, err := ParseExpr("mem[0 - 1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Eval(m); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("mem[0 - 1] error = %v, want out of range", err)
+	}
+}